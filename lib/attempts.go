@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ErrAttemptFailed is wrapped by the errors of an attempt to update an instance which can be retried
+var ErrAttemptFailed = errors.New("update attempt failed")
+
+// ErrInstancesFailed is returned when a rollout ends with instances given up after MaxAttempts
+var ErrInstancesFailed = errors.New("instances could not be updated")
+
+// InstanceFailure is an instance given up with the error of its last attempt
+type InstanceFailure struct {
+	Node      string
+	ServiceID string
+	Reason    string
+}
+
+// RolloutReport lists the instances updated by a rollout, the outdated instances it left alone and
+// the instances it gave up
+type RolloutReport struct {
+	Updated []string
+	Skipped []string
+	Failed  []InstanceFailure
+}
+
+// Summary returns the number of instances updated, skipped and failed
+func (r RolloutReport) Summary() string {
+	return fmt.Sprintf("%d updated, %d skipped, %d failed", len(r.Updated), len(r.Skipped), len(r.Failed))
+}
+
+// FailedNodes returns the nodes of the instances given up
+func (r RolloutReport) FailedNodes() []string {
+	var nodes []string
+	for _, f := range r.Failed {
+		nodes = append(nodes, f.Node)
+	}
+	return nodes
+}
+
+func (r RolloutReport) log() {
+	log.Println("Rollout report:", r.Summary())
+	if len(r.Updated) > 0 {
+		log.Println("Updated:", strings.Join(r.Updated, ", "))
+	}
+	if len(r.Skipped) > 0 {
+		log.Println("Skipped:", strings.Join(r.Skipped, ", "))
+	}
+	for _, f := range r.Failed {
+		log.Println("Failed:", f.Node, f.ServiceID, f.Reason)
+	}
+}
+
+// Report returns what became of the instances so far
+func (u *Updater) Report() RolloutReport {
+	return u.report
+}
+
+func (u *Updater) maxAttempts() int {
+	if u.MaxAttempts <= 0 {
+		return 3
+	}
+	return u.MaxAttempts
+}
+
+// withoutFailed drops the instances given up from services
+func (u *Updater) withoutFailed(services []*api.CatalogService) []*api.CatalogService {
+	var kept []*api.CatalogService
+	for _, s := range services {
+		if u.attempts[instanceKey(s.Node, s.ServiceID)] >= u.maxAttempts() {
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept
+}
+
+// attemptFailed counts a failed attempt to update an instance, the instance is given up and its
+// rollout state removed after MaxAttempts
+func (u *Updater) attemptFailed(ctx context.Context, cs *CatalogService, err error) {
+	if u.attempts == nil {
+		u.attempts = make(map[string]int)
+	}
+	key := instanceKey(cs.Cs.Node, cs.Cs.ServiceID)
+	u.attempts[key]++
+	log.Println("Attempt", u.attempts[key], "of", u.maxAttempts(), "to update node", cs.Cs.Node, "failed:", err)
+	u.record(JournalEntry{Event: "attempt-failed", Node: cs.Cs.Node, Message: err.Error()})
+	if u.attempts[key] < u.maxAttempts() {
+		return
+	}
+	log.Println("Giving up node", cs.Cs.Node, "after", u.attempts[key], "attempts")
+	u.report.Failed = append(u.report.Failed, InstanceFailure{Node: cs.Cs.Node, ServiceID: cs.Cs.ServiceID, Reason: err.Error()})
+	u.untagNode(ctx, cs)
+	u.record(JournalEntry{Event: "failed", Node: cs.Cs.Node, Message: err.Error()})
+}
+
+// finish records the outdated instances left alone and fails when instances were given up
+func (u *Updater) finish(services []*api.CatalogService) error {
+	for _, s := range services {
+		if !u.state.Updated(s) {
+			u.report.Skipped = append(u.report.Skipped, s.Node)
+		}
+	}
+	u.record(JournalEntry{Event: "rollout-end", Message: u.report.Summary()})
+	if len(u.report.Failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrInstancesFailed, strings.Join(u.report.FailedNodes(), ", "))
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestWithoutFailed(t *testing.T) {
+	u := &Updater{MaxAttempts: 2, attempts: map[string]int{
+		instanceKey("node1", "wowza-edge"): 2,
+		instanceKey("node2", "wowza-edge"): 1,
+	}}
+	services := u.withoutFailed(healthServices("node1", "node2", "node3"))
+	if !sameNodes(services, "node2", "node3") {
+		t.Error("node1 should be given up", nodes(services))
+	}
+}
+
+func TestFinishReportsSkippedAndFailed(t *testing.T) {
+	u := &Updater{Journal: NewJournal(""), state: RolloutState{Image: "wowza:2"}}
+	u.report.Updated = []string{"node1"}
+	u.report.Failed = []InstanceFailure{{Node: "node4", ServiceID: "wowza-edge", Reason: "no unit found"}}
+	services := []*api.CatalogService{
+		{Node: "node1", ServiceTags: []string{"image=wowza:2"}},
+		{Node: "node2", ServiceTags: []string{"image=wowza:1"}},
+	}
+	err := u.finish(services)
+	if err == nil || !errors.Is(err, ErrInstancesFailed) {
+		t.Error("The rollout should fail with node4 given up", err)
+	}
+	report := u.Report()
+	if len(report.Skipped) != 1 || report.Skipped[0] != "node2" || report.Summary() != "1 updated, 1 skipped, 1 failed" {
+		t.Error("Unexpected report", report)
+	}
+}
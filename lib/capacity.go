@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// What the updater does when draining another instance would leave too little capacity
+const (
+	// CapacityWait waits until the connections drop
+	CapacityWait = "wait"
+	// CapacityAbort stops the rollout
+	CapacityAbort = "abort"
+)
+
+// ErrCapacity is returned when the instances left would use more than MaxUtilisation of their connections
+var ErrCapacity = errors.New("not enough Wowza capacity left")
+
+// ParseCapacityAction checks a capacity action given on the command line
+func ParseCapacityAction(s string) (string, error) {
+	switch s {
+	case CapacityWait, CapacityAbort:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown capacity action %q, expected wait or abort", s)
+}
+
+// ProjectedUtilisation returns the percentage of the MaxConnections of the instances passing their
+// checks used once the connections of candidate moved to them. Instances without metrics are
+// counted without capacity, the capacity is unknown when candidate has no metrics.
+func ProjectedUtilisation(candidate *api.CatalogService, services []*api.CatalogService, health ServiceHealth, metrics map[string]Metrics) (float64, error) {
+	candidateKey := instanceKey(candidate.Node, candidate.ServiceID)
+	candidateMetrics, ok := metrics[candidateKey]
+	if !ok {
+		return 0, fmt.Errorf("%w: capacity unknown, the connections of node %s could not be read", ErrCapacity, candidate.Node)
+	}
+	current := int64(candidateMetrics.CurrentConnections)
+	var max int64
+	for _, s := range services {
+		key := instanceKey(s.Node, s.ServiceID)
+		if key == candidateKey || !health.Passing(s) {
+			continue
+		}
+		if m, ok := metrics[key]; ok {
+			current += int64(m.CurrentConnections)
+			max += int64(m.MaxConnections)
+		}
+	}
+	if max == 0 {
+		return 0, fmt.Errorf("%w: no other passing instance reports its maximum connections", ErrCapacity)
+	}
+	return 100 * float64(current) / float64(max), nil
+}
+
+// checkCapacity checks that draining candidate leaves the other passing instances under
+// MaxUtilisation, it always passes when MaxUtilisation is zero or candidate already fails its checks
+func (u *Updater) checkCapacity(ctx context.Context, candidate *api.CatalogService, services []*api.CatalogService, health ServiceHealth) error {
+	if u.MaxUtilisation <= 0 || !health.Passing(candidate) {
+		return nil
+	}
+	var measured []*api.CatalogService
+	var urls []string
+	for _, s := range services {
+		if health.Passing(s) {
+			measured = append(measured, s)
+			urls = append(urls, (&CatalogService{Dc: u.Dc, Cs: s}).GetURL())
+		}
+	}
+	metrics := make(map[string]Metrics)
+	for i, r := range CollectMetrics(ctx, urls, u.Transport, u.MetricsConcurrency, u.MetricsTimeout) {
+		if r.Err != nil {
+			log.Println("Unable to retrieve wowza metrics for node", measured[i].Node, r.Err)
+			continue
+		}
+		metrics[instanceKey(measured[i].Node, measured[i].ServiceID)] = r.Metrics
+	}
+	utilisation, err := ProjectedUtilisation(candidate, services, health, metrics)
+	if err != nil {
+		return err
+	}
+	if utilisation > u.MaxUtilisation {
+		return fmt.Errorf("%w: draining node %s would use %.1f%% of the connections of the other passing instances, at most %.1f%% allowed",
+			ErrCapacity, candidate.Node, utilisation, u.MaxUtilisation)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestParseCapacityAction(t *testing.T) {
+	if _, err := ParseCapacityAction("pause"); err == nil {
+		t.Error("pause is not a capacity action")
+	}
+	if action, err := ParseCapacityAction(CapacityAbort); err != nil || action != CapacityAbort {
+		t.Error("Unexpected capacity action", action, err)
+	}
+}
+
+func TestProjectedUtilisation(t *testing.T) {
+	services := healthServices("node1", "node2", "node3", "node4")
+	health := NewServiceHealth([]*api.ServiceEntry{
+		healthEntry("node1", api.HealthPassing),
+		healthEntry("node2", api.HealthPassing),
+		healthEntry("node3", api.HealthPassing),
+		healthEntry("node4", api.HealthCritical),
+	})
+	metrics := map[string]Metrics{
+		instanceKey("node1", "wowza-edge"): {CurrentConnections: 50, MaxConnections: 100},
+		instanceKey("node2", "wowza-edge"): {CurrentConnections: 40, MaxConnections: 100},
+		instanceKey("node3", "wowza-edge"): {CurrentConnections: 30, MaxConnections: 100},
+		instanceKey("node4", "wowza-edge"): {CurrentConnections: 0, MaxConnections: 100},
+	}
+	// the 50 connections of node1 move to node2 and node3, node4 fails its checks
+	utilisation, err := ProjectedUtilisation(services[0], services, health, metrics)
+	if err != nil || utilisation != 60 {
+		t.Error("Unexpected utilisation", utilisation, err)
+	}
+	delete(metrics, instanceKey("node1", "wowza-edge"))
+	if _, err := ProjectedUtilisation(services[0], services, health, metrics); !errors.Is(err, ErrCapacity) {
+		t.Error("The capacity is unknown without the connections of node1", err)
+	}
+	metrics[instanceKey("node1", "wowza-edge")] = Metrics{CurrentConnections: 50, MaxConnections: 100}
+	delete(metrics, instanceKey("node3", "wowza-edge"))
+	utilisation, err = ProjectedUtilisation(services[0], services, health, metrics)
+	if err != nil || utilisation != 90 {
+		t.Error("node3 without metrics has no capacity", utilisation, err)
+	}
+	delete(metrics, instanceKey("node2", "wowza-edge"))
+	if _, err := ProjectedUtilisation(services[0], services, health, metrics); !errors.Is(err, ErrCapacity) {
+		t.Error("No capacity is left", err)
+	}
+}
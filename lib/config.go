@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration written as "30m" in the configuration file
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration such as "1h30m"
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration %s should be a string such as \"30m\"", b)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes a duration such as "1h30m0s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// DatacenterConfig is a Consul datacenter of a rollout with the fleet cluster it runs on
+type DatacenterConfig struct {
+	Name           string `json:"name"`
+	FleetSSHServer string `json:"fleet_ssh_server"`
+	// FleetSSHUser is the -fleet-ssh-user when empty
+	FleetSSHUser string `json:"fleet_ssh_user,omitempty"`
+}
+
+// Config is the rollout configuration file
+type Config struct {
+	// Datacenters are rolled in order, each one has to finish and stay healthy for Soak before the next one
+	Datacenters []DatacenterConfig `json:"datacenters"`
+	Soak        Duration           `json:"soak"`
+	// Schedule restricts when a new instance starts being updated
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// LoadConfig reads a JSON configuration file
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	f, err := os.Open(path)
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+	return config, nil
+}
+
+func (c Config) validate() error {
+	seen := make(map[string]bool)
+	for i, dc := range c.Datacenters {
+		if dc.Name == "" {
+			return fmt.Errorf("datacenter %d has no name", i+1)
+		}
+		if dc.FleetSSHServer == "" {
+			return fmt.Errorf("datacenter %s has no fleet_ssh_server", dc.Name)
+		}
+		if seen[dc.Name] {
+			return fmt.Errorf("datacenter %s is listed twice", dc.Name)
+		}
+		seen[dc.Name] = true
+	}
+	if c.Soak < 0 {
+		return errors.New("soak can't be negative")
+	}
+	if c.Schedule != nil {
+		return c.Schedule.parse()
+	}
+	return nil
+}
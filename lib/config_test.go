@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{
+		"datacenters": [
+			{"name": "eu-west", "fleet_ssh_server": "fleet.eu-west:22"},
+			{"name": "us-east", "fleet_ssh_server": "fleet.us-east:22", "fleet_ssh_user": "deploy"}
+		],
+		"soak": "15m"
+	}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Datacenters) != 2 || config.Datacenters[1].FleetSSHUser != "deploy" || time.Duration(config.Soak) != 15*time.Minute {
+		t.Error("Unexpected configuration", config)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"datacenters": [{"fleet_ssh_server": "fleet:22"}]}`,
+		`{"datacenters": [{"name": "eu-west"}]}`,
+		`{"datacenters": [{"name": "eu-west", "fleet_ssh_server": "a:22"}, {"name": "eu-west", "fleet_ssh_server": "b:22"}]}`,
+		`{"soak": "-1m"}`,
+		`{"soak": 60}`,
+		`{"datacenter": []}`,
+	} {
+		if _, err := LoadConfig(writeConfig(t, content)); err == nil {
+			t.Error("Configuration should be refused:", content)
+		}
+	}
+}
+
+func TestMultiDCRolloutUpdater(t *testing.T) {
+	m := MultiDCRollout{Base: Updater{Service: "wowza-edge", Dc: "dc1", FleetSSHUser: "core", FleetSSHServer: "fleet:22"}}
+	u := m.Updater(DatacenterConfig{Name: "us-east", FleetSSHServer: "fleet.us-east:22", FleetSSHUser: "deploy"})
+	if u.Dc != "us-east" || u.FleetSSHServer != "fleet.us-east:22" || u.FleetSSHUser != "deploy" || u.Service != "wowza-edge" {
+		t.Error("Unexpected updater", u.Dc, u.FleetSSHServer, u.FleetSSHUser)
+	}
+	u = m.Updater(DatacenterConfig{Name: "eu-west", FleetSSHServer: "fleet.eu-west:22"})
+	if u.FleetSSHUser != "core" || m.Base.Dc != "dc1" {
+		t.Error("The base updater should be kept", u.FleetSSHUser, m.Base.Dc)
+	}
+}
+
+func TestCheckServicesHealthy(t *testing.T) {
+	report := RolloutReport{Updated: []string{"node1", "node2"}}
+	services := healthServices("node1", "node2")
+	health := NewServiceHealth([]*api.ServiceEntry{
+		healthEntry("node1", api.HealthPassing),
+		healthEntry("node2", api.HealthPassing),
+	})
+	if err := CheckServicesHealthy(services, health, report); err != nil {
+		t.Error(err)
+	}
+	health = NewServiceHealth([]*api.ServiceEntry{healthEntry("node1", api.HealthPassing)})
+	if err := CheckServicesHealthy(services, health, report); !errors.Is(err, ErrSoakFailed) {
+		t.Error("node2 has no check result", err)
+	}
+	if err := CheckServicesHealthy(services, health, RolloutReport{Updated: []string{"node1"}, Skipped: []string{"node2"}}); err != nil {
+		t.Error("A skipped instance should not fail the soak", err)
+	}
+	report = RolloutReport{Updated: []string{"node1"}, Failed: []InstanceFailure{{Node: "node2", ServiceID: "wowza-edge"}}}
+	if err := CheckServicesHealthy(services, health, report); err != nil {
+		t.Error("An instance given up should not fail the soak", err)
+	}
+	if err := CheckServicesHealthy(nil, health, report); !errors.Is(err, ErrSoakFailed) {
+		t.Error("No service should fail", err)
+	}
+}
+
+func TestLoadConfigSchedule(t *testing.T) {
+	path := writeConfig(t, `{
+		"schedule": {
+			"timezone": "Europe/Paris",
+			"windows": [{"days": ["mon", "tue"], "start": "02:00", "end": "06:00"}],
+			"blackouts": [{"from": "2026-12-24", "to": "2026-12-26", "reason": "christmas"}]
+		}
+	}`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open, reason := config.Schedule.Open(time.Date(2026, 12, 25, 3, 0, 0, 0, time.UTC)); open || reason != "blackout: christmas" {
+		t.Error("Christmas should be blacked out", open, reason)
+	}
+	if _, err := LoadConfig(writeConfig(t, `{"schedule": {"windows": [{"days": ["mon"], "start": "25:00", "end": "06:00"}]}}`)); err == nil {
+		t.Error("25:00 is not a time")
+	}
+}
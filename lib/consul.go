@@ -1,49 +1,18 @@
 package lib
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"strings"
 
 	"github.com/hashicorp/consul/api"
 )
 
-// Tag for a service
-type Tag struct {
-	Key   string
-	Value string
-}
-
-func split(s, sep string) (string, string) {
-	x := strings.Split(s, sep)
-	return x[0], x[1]
-}
-
-// BuildTag build a tag string from a tag struct
-func (t Tag) BuildTag() (string, error) {
-	var err error
-	if t.Key == "" || t.Value == "" {
-		err = errors.New("Should not build tag with empty key or value")
-		fmt.Println("Error while building tag :", err.Error())
-	}
-	if err != nil {
-		return "", err
-	} else {
-		return fmt.Sprintf("%s=%s", t.Key, t.Value), err
-	}
-}
-
-//DeconstructTag allow to construct a tag from a given string containing key/value separated with =
-func (t *Tag) DeconstructTag(tag string) {
-	k, v := split(tag, "=")
-	t.Key = k
-	t.Value = v
-}
-
 // CatalogService extends api.CatalogService
 type CatalogService struct {
 	Cs *api.CatalogService
 	Dc string
+	// TagUpdater writes the tags of the service, tags are registered in the catalog when nil
+	TagUpdater *TagUpdater
 }
 
 // GetURL build and url from given CatalogService
@@ -54,91 +23,96 @@ func (cs *CatalogService) GetURL() string {
 
 // HasTag check if catalog service has a given tag
 func (cs *CatalogService) HasTag(tag Tag) bool {
-	for _, t := range cs.Cs.ServiceTags {
-		newtag, _ := tag.BuildTag()
-		if t == newtag {
-			return true
-		}
+	return TagSet(cs.Cs.ServiceTags).Has(tag)
+}
+
+// HasLabel reports whether a service has the label as a meta key or as a tag
+func (cs *CatalogService) HasLabel(label Tag) bool {
+	if v, ok := cs.Cs.ServiceMeta[label.Key]; ok && v == label.Value {
+		return true
 	}
-	return false
+	return cs.HasTag(label)
 }
 
-func (cs *CatalogService) serviceRegister(c *api.Client) {
-	reg := api.CatalogRegistration{
-		Node:            cs.Cs.Node,
-		Address:         cs.Cs.Address,
-		Datacenter:      cs.Dc,
-		TaggedAddresses: cs.Cs.TaggedAddresses,
-		Service: &api.AgentService{
-			ID:                cs.Cs.ServiceID,
-			Service:           cs.Cs.ServiceName,
-			Tags:              cs.Cs.ServiceTags,
-			Port:              cs.Cs.ServicePort,
-			Address:           cs.Cs.ServiceAddress,
-			EnableTagOverride: true,
-		},
+// tagUpdater returns how the tags of the service are written
+func (cs *CatalogService) tagUpdater() *TagUpdater {
+	if cs.TagUpdater == nil {
+		return defaultTagUpdater
 	}
-	c.Catalog().Register(&reg, nil)
-	fmt.Printf("%s service for node %s registered with tags %s\n", cs.Cs.ServiceName, cs.Cs.Node, cs.Cs.ServiceTags)
+	return cs.TagUpdater
 }
 
 //ServiceAddTag allow to add a tag on a service
 func (cs *CatalogService) ServiceAddTag(c *api.Client, s *api.CatalogService, tag Tag) error {
-	if !cs.HasTag(tag) {
-		fmt.Println("ADD TAG : ", tag.Key)
-		newTag, err := tag.BuildTag()
-		if err != nil {
-			fmt.Println("Error while building tag to add to service : ", err.Error())
-			panic(err.Error())
+	return cs.tagUpdater().Update(context.Background(), c, cs, func(tags *TagSet, meta map[string]string) (bool, error) {
+		added, err := tags.Add(tag)
+		if added {
+			fmt.Println("ADD TAG : ", tag.Key)
 		}
-		cs.Cs.ServiceTags = append(cs.Cs.ServiceTags, newTag)
-		cs.serviceRegister(c)
-	}
-	return nil
+		return added, err
+	})
+}
+
+// ServiceSetTag adds a tag on a service, replacing any tag with the same key
+func (cs *CatalogService) ServiceSetTag(c *api.Client, s *api.CatalogService, tag Tag) error {
+	return cs.ServiceSetTagWithContext(context.Background(), c, s, tag)
+}
+
+// ServiceSetTagWithContext adds a tag on a service like ServiceSetTag, ctx cuts the write
+func (cs *CatalogService) ServiceSetTagWithContext(ctx context.Context, c *api.Client, s *api.CatalogService, tag Tag) error {
+	return cs.tagUpdater().Update(ctx, c, cs, func(tags *TagSet, meta map[string]string) (bool, error) {
+		changed, err := tags.Replace(tag)
+		if changed {
+			fmt.Println("SET TAG : ", tag.Key)
+		}
+		return changed, err
+	})
 }
 
 //ServiceDeleteTag allow to delete a tag on a service
 func (cs *CatalogService) ServiceDeleteTag(c *api.Client, s *api.CatalogService, tag Tag) error {
-	if !cs.HasTag(tag) {
-		return nil
-	}
-	var tags []string
-	for _, t := range cs.Cs.ServiceTags {
-		newtag, err := tag.BuildTag()
-		if err != nil {
-			fmt.Println("Error while building tag to add to service : ", err.Error())
-			panic(err.Error())
-		}
-		if t != newtag {
-			tags = append(tags, t)
+	return cs.tagUpdater().Update(context.Background(), c, cs, func(tags *TagSet, meta map[string]string) (bool, error) {
+		return tags.Remove(tag), nil
+	})
+}
+
+// ServiceDeleteTagKey deletes every tag with the given key on a service
+func (cs *CatalogService) ServiceDeleteTagKey(c *api.Client, s *api.CatalogService, key string) error {
+	return cs.tagUpdater().Update(context.Background(), c, cs, func(tags *TagSet, meta map[string]string) (bool, error) {
+		return tags.RemoveKey(key), nil
+	})
+}
+
+// SearchService returns the first service matching
+func SearchService(c []*api.CatalogService, match func(*api.CatalogService) bool) (api.CatalogService, bool) {
+	for _, s := range c {
+		if match(s) {
+			return *s, true
 		}
 	}
-	cs.Cs.ServiceTags = tags
-	cs.serviceRegister(c)
-
-	return nil
+	return api.CatalogService{}, false
 }
 
 //SearchServiceWithoutTag allow to search a service without a given tag, return the first that doesn't have this tag
 func SearchServiceWithoutTag(c []*api.CatalogService, unexpectedTag Tag) (api.CatalogService, error) {
-	var ret api.CatalogService
-	for _, s := range c {
+	s, ok := SearchService(c, func(s *api.CatalogService) bool {
 		cs := CatalogService{Cs: s}
-		if !cs.HasTag(unexpectedTag) {
-			return *s, nil
-		}
+		return !cs.HasTag(unexpectedTag)
+	})
+	if !ok {
+		return s, fmt.Errorf("Cannot found instance without tag %s", unexpectedTag)
 	}
-	return ret, fmt.Errorf("Cannot found instance without tag %s", unexpectedTag)
+	return s, nil
 }
 
 //SearchServiceWithTag allow to search a service with a given tag, return the first that do have this tag
 func SearchServiceWithTag(c []*api.CatalogService, expectedTag Tag) (api.CatalogService, error) {
-	var ret api.CatalogService
-	for _, s := range c {
+	s, ok := SearchService(c, func(s *api.CatalogService) bool {
 		cs := CatalogService{Cs: s}
-		if cs.HasTag(expectedTag) {
-			return *s, nil
-		}
+		return cs.HasTag(expectedTag)
+	})
+	if !ok {
+		return s, fmt.Errorf("Cannot found instance with tag %s", expectedTag)
 	}
-	return ret, fmt.Errorf("Cannot found instance with tag %s", expectedTag)
+	return s, nil
 }
@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ConsulOptions configures the Consul client, empty options keep the defaults of the Consul
+// environment variables (CONSUL_HTTP_ADDR, CONSUL_HTTP_TOKEN, ...)
+type ConsulOptions struct {
+	Address   string
+	Scheme    string
+	Token     string
+	TokenFile string
+	Namespace string
+	// CAFile, CertFile and KeyFile are PEM files, ServerName is the name checked against the server certificate
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// Config returns the Consul client configuration, files given are checked to be readable
+func (o ConsulOptions) Config() (*api.Config, error) {
+	conf := api.DefaultConfig()
+	if o.Address != "" {
+		conf.Address = o.Address
+	}
+	switch o.Scheme {
+	case "":
+	case "http", "https":
+		conf.Scheme = o.Scheme
+	default:
+		return nil, fmt.Errorf("unknown Consul scheme %q, expected http or https", o.Scheme)
+	}
+	if o.Token != "" && o.TokenFile != "" {
+		return nil, errors.New("a Consul token and a Consul token file can't be both given")
+	}
+	if o.Token != "" {
+		conf.Token = o.Token
+		conf.TokenFile = ""
+	}
+	if o.TokenFile != "" {
+		conf.TokenFile = o.TokenFile
+		conf.Token = ""
+	}
+	if o.Namespace != "" {
+		conf.Namespace = o.Namespace
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errors.New("a Consul client certificate needs both a certificate file and a key file")
+	}
+	if o.CAFile != "" {
+		conf.TLSConfig.CAFile = o.CAFile
+	}
+	if o.CertFile != "" {
+		conf.TLSConfig.CertFile = o.CertFile
+		conf.TLSConfig.KeyFile = o.KeyFile
+	}
+	if o.ServerName != "" {
+		conf.TLSConfig.Address = o.ServerName
+	}
+	if (o.CAFile != "" || o.CertFile != "" || o.ServerName != "") && o.Scheme == "" {
+		conf.Scheme = "https"
+	}
+	for _, f := range []struct{ name, path string }{
+		{"token file", conf.TokenFile},
+		{"CA file", conf.TLSConfig.CAFile},
+		{"certificate file", conf.TLSConfig.CertFile},
+		{"key file", conf.TLSConfig.KeyFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			return nil, fmt.Errorf("unable to read Consul %s: %v", f.name, err)
+		}
+	}
+	return conf, nil
+}
+
+// NewConsulClient returns a Consul client configured with the options
+func NewConsulClient(o ConsulOptions) (*api.Client, *api.Config, error) {
+	conf, err := o.Config()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := api.NewClient(conf)
+	if err != nil {
+		return nil, nil, ExplainConsulError(err, "")
+	}
+	return client, conf, nil
+}
+
+// ExplainConsulError adds to a Consul error what to check, permission is the ACL permission the
+// request needs, such as service:write
+func ExplainConsulError(err error, permission string) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "ACL not found"):
+		return fmt.Errorf("%w: the Consul token is unknown, check -consul-token or -consul-token-file", err)
+	case strings.Contains(msg, "403") || strings.Contains(strings.ToLower(msg), "permission denied"):
+		if permission == "" {
+			return fmt.Errorf("%w: the Consul token lacks a permission", err)
+		}
+		return fmt.Errorf("%w: the Consul token needs %s", err, permission)
+	case strings.Contains(msg, "x509") || strings.Contains(msg, "certificate"):
+		return fmt.Errorf("%w: TLS verification failed, check -consul-ca-file and -consul-tls-server-name", err)
+	case strings.Contains(msg, "HTTP response to HTTPS client"):
+		return fmt.Errorf("%w: Consul does not serve HTTPS on this address, check -consul-scheme and -consul-address", err)
+	case strings.Contains(msg, "malformed HTTP response") || strings.Contains(msg, "tls: first record does not look like a TLS handshake"):
+		return fmt.Errorf("%w: Consul serves HTTPS on this address, use -consul-scheme https", err)
+	case strings.Contains(msg, "connection refused") || strings.Contains(msg, "no such host"):
+		return fmt.Errorf("%w: Consul is unreachable, check -consul-address", err)
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConsulOptionsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(ca, []byte("ca"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := ConsulOptions{Address: "consul.service:8501", Token: "secret", CAFile: ca, ServerName: "server.dc1.consul"}.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Address != "consul.service:8501" || conf.Scheme != "https" || conf.Token != "secret" || conf.TLSConfig.CAFile != ca || conf.TLSConfig.Address != "server.dc1.consul" {
+		t.Error("Unexpected Consul config", conf)
+	}
+
+	for _, o := range []ConsulOptions{
+		{Scheme: "ftp"},
+		{Token: "secret", TokenFile: ca},
+		{CertFile: ca},
+		{TokenFile: filepath.Join(dir, "missing")},
+	} {
+		if _, err := o.Config(); err == nil {
+			t.Error("Options should be refused", o)
+		}
+	}
+}
+
+func TestExplainConsulError(t *testing.T) {
+	err := ExplainConsulError(errors.New("Unexpected response code: 403 (Permission denied)"), "service:write on wowza-edge")
+	if !strings.Contains(err.Error(), "needs service:write on wowza-edge") {
+		t.Error("Missing permission should be explained", err)
+	}
+	err = ExplainConsulError(errors.New("Unexpected response code: 403 (ACL not found)"), "service:write on wowza-edge")
+	if !strings.Contains(err.Error(), "token is unknown") {
+		t.Error("Unknown token should be explained", err)
+	}
+	original := errors.New("boom")
+	if ExplainConsulError(original, "") != original || ExplainConsulError(nil, "") != nil {
+		t.Error("Other errors should be kept as they are")
+	}
+}
@@ -0,0 +1,331 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Ways of writing the tags of a service instance
+const (
+	// TagUpdateCatalog writes the instance in the catalog with a check-and-set transaction
+	TagUpdateCatalog = "catalog"
+	// TagUpdateAgent registers the instance again through the Consul agent of its node
+	TagUpdateAgent = "agent"
+
+	// DefaultAgentPort is the HTTP port of Consul agents
+	DefaultAgentPort = 8500
+)
+
+// ErrTagNotApplied is returned when the catalog does not show the new tags of an instance
+var ErrTagNotApplied = errors.New("tag change not visible in the catalog")
+
+// ErrTagConflict is returned when the check-and-set write of an instance is rolled back, usually
+// because the instance changed since it was read
+var ErrTagConflict = errors.New("tag change rolled back")
+
+// ParseTagUpdateStrategy checks a tag update strategy given on the command line
+func ParseTagUpdateStrategy(s string) (string, error) {
+	switch s {
+	case TagUpdateCatalog, TagUpdateAgent:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown tag update strategy %q, expected catalog or agent", s)
+}
+
+// TagUpdater writes the tags of service instances. Tags are changed on the latest version of the
+// instance read from the catalog and written with a check-and-set on its ModifyIndex: when the
+// instance changed meanwhile, it is read again and the change applied again, up to Attempts times.
+// The agent strategy can't check-and-set, a change made between the read and the write is lost.
+// The change is then read back.
+type TagUpdater struct {
+	Strategy string
+	// Config is the base configuration of clients to Consul agents, for the agent strategy
+	Config *api.Config
+	// AgentPort is the HTTP port of Consul agents, DefaultAgentPort when zero
+	AgentPort int
+	// Attempts bounds the number of writes, VerifyTimeout bounds the wait for a write to be read back
+	Attempts      int
+	VerifyTimeout time.Duration
+}
+
+// defaultTagUpdater is used by catalog services without tag updater
+var defaultTagUpdater = &TagUpdater{Strategy: TagUpdateCatalog}
+
+func (tu *TagUpdater) attempts() int {
+	if tu.Attempts <= 0 {
+		return 5
+	}
+	return tu.Attempts
+}
+
+func (tu *TagUpdater) verifyTimeout() time.Duration {
+	if tu.VerifyTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return tu.VerifyTimeout
+}
+
+// readInstance reads the current catalog entry of a service instance
+func readInstance(ctx context.Context, c *api.Client, dc string, s *api.CatalogService) (*api.CatalogService, error) {
+	services, _, err := c.Catalog().Service(s.ServiceName, "", (&api.QueryOptions{Datacenter: dc, RequireConsistent: true}).WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, ExplainConsulError(err, "service:read on "+s.ServiceName)
+	}
+	if current := findInstance(services, s); current != nil {
+		return current, nil
+	}
+	return nil, fmt.Errorf("service %s is no longer registered on node %s", s.ServiceID, s.Node)
+}
+
+func sameMeta(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func sameTags(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Update applies mutate to the tags and meta of the instance, cs is refreshed with the catalog entry
+// read back. mutate reports whether the tags or meta changed. ctx cuts the reads, writes and waits.
+func (tu *TagUpdater) Update(ctx context.Context, c *api.Client, cs *CatalogService, mutate func(tags *TagSet, meta map[string]string) (bool, error)) error {
+	var conflict error
+	for attempt := 1; ; attempt++ {
+		current, err := readInstance(ctx, c, cs.Dc, cs.Cs)
+		if err != nil {
+			return err
+		}
+		if conflict != nil && current.ModifyIndex == cs.Cs.ModifyIndex {
+			// the instance did not change, the transaction was rolled back for another reason
+			return conflict
+		}
+		if cs.Cs.ModifyIndex != 0 && current.ModifyIndex != cs.Cs.ModifyIndex {
+			log.Println("Service", current.ServiceID, "on node", current.Node, "changed since it was read, applying tags to its latest version")
+		}
+		tags := NewTagSet(current.ServiceTags)
+		meta := make(map[string]string)
+		for k, v := range current.ServiceMeta {
+			meta[k] = v
+		}
+		changed, err := mutate(&tags, meta)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			cs.Cs = current
+			return nil
+		}
+		if !current.ServiceEnableTagOverride {
+			log.Println("Tag override is disabled on service", current.ServiceID, "on node", current.Node+",",
+				"the agent may revert its tags, register it with EnableTagOverride or use the agent tag update strategy")
+		}
+		err = tu.write(ctx, c, cs.Dc, current, tags, meta)
+		if errors.Is(err, ErrTagConflict) && attempt < tu.attempts() {
+			conflict = err
+			cs.Cs = current
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		conflict = nil
+		updated, err := tu.readBack(ctx, c, cs.Dc, current, tags, meta)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			cs.Cs = updated
+			fmt.Printf("%s service for node %s registered with tags %s\n", updated.ServiceName, updated.Node, updated.ServiceTags)
+			return nil
+		}
+		if attempt >= tu.attempts() {
+			return err
+		}
+		// the instance was changed meanwhile, apply the change again
+		cs.Cs = current
+	}
+}
+
+// readBack waits with blocking queries until the catalog shows the tags and meta written
+func (tu *TagUpdater) readBack(ctx context.Context, c *api.Client, dc string, s *api.CatalogService, tags []string, meta map[string]string) (*api.CatalogService, error) {
+	var current *api.CatalogService
+	q := &api.QueryOptions{Datacenter: dc, RequireConsistent: true}
+	_, err := WaitService(ctx, c, s.ServiceName, q, time.Now().Add(tu.verifyTimeout()), func(services []*api.CatalogService) bool {
+		current = findInstance(services, s)
+		return current != nil && sameTags(current.ServiceTags, tags) && sameMeta(current.ServiceMeta, meta)
+	})
+	switch {
+	case err == nil:
+		return current, nil
+	case !errors.Is(err, ErrWaitTimeout):
+		return nil, fmt.Errorf("%w: %s on node %s: %v", ErrTagNotApplied, s.ServiceID, s.Node, err)
+	case current == nil:
+		return nil, fmt.Errorf("%w: %s is no longer registered on node %s", ErrTagNotApplied, s.ServiceID, s.Node)
+	}
+	return nil, fmt.Errorf("%w: %s on node %s has tags %s and meta %v, expected %s and %v", ErrTagNotApplied, s.ServiceID, s.Node, current.ServiceTags, current.ServiceMeta, tags, meta)
+}
+
+func (tu *TagUpdater) write(ctx context.Context, c *api.Client, dc string, s *api.CatalogService, tags []string, meta map[string]string) error {
+	if tu.Strategy == TagUpdateAgent {
+		return tu.agentRegister(s, tags, meta)
+	}
+	ok, resp, _, err := c.Txn().Txn(api.TxnOps{catalogCAS(s, tags, meta)}, (&api.QueryOptions{Datacenter: dc}).WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("unable to write service %s on node %s: %w", s.ServiceID, s.Node,
+			ExplainConsulError(err, fmt.Sprintf("node:write on %s and service:write on %s", s.Node, s.ServiceName)))
+	}
+	if !ok {
+		var reasons []string
+		if resp != nil {
+			for _, e := range resp.Errors {
+				reasons = append(reasons, e.What)
+			}
+		}
+		return fmt.Errorf("%w: service %s on node %s: %s", ErrTagConflict, s.ServiceID, s.Node, strings.Join(reasons, ", "))
+	}
+	return nil
+}
+
+// catalogCAS writes the instance with new tags and meta if its ModifyIndex did not move, its checks
+// are left untouched as the operation carries none
+func catalogCAS(s *api.CatalogService, tags []string, meta map[string]string) *api.TxnOp {
+	return &api.TxnOp{
+		Service: &api.ServiceTxnOp{
+			Verb: api.ServiceCAS,
+			Node: s.Node,
+			Service: api.AgentService{
+				ID:                s.ServiceID,
+				Service:           s.ServiceName,
+				Tags:              tags,
+				Meta:              meta,
+				Port:              s.ServicePort,
+				Address:           s.ServiceAddress,
+				Weights:           api.AgentWeights{Passing: s.ServiceWeights.Passing, Warning: s.ServiceWeights.Warning},
+				EnableTagOverride: s.ServiceEnableTagOverride,
+				ModifyIndex:       s.ModifyIndex,
+			},
+		},
+	}
+}
+
+// agentRegistration registers a service known by an agent again with new tags and meta. The agent
+// removes the checks missing from a registration, so the checks of the service are registered again
+// with their current status. Checks which can't be rebuilt from their definition, such as TTL or
+// script checks, are refused.
+func agentRegistration(s *api.AgentService, checks map[string]*api.AgentCheck, tags []string, meta map[string]string) (*api.AgentServiceRegistration, error) {
+	var serviceChecks api.AgentServiceChecks
+	for _, c := range checks {
+		if c.ServiceID != s.ID {
+			continue
+		}
+		d := c.Definition
+		if d.HTTP == "" && d.TCP == "" {
+			return nil, fmt.Errorf("check %s of service %s can't be registered again through the agent, use the catalog tag update strategy", c.CheckID, s.ID)
+		}
+		check := &api.AgentServiceCheck{
+			CheckID:       c.CheckID,
+			Name:          c.Name,
+			Notes:         c.Notes,
+			Status:        c.Status,
+			HTTP:          d.HTTP,
+			Header:        d.Header,
+			Method:        d.Method,
+			TLSSkipVerify: d.TLSSkipVerify,
+			TCP:           d.TCP,
+			Interval:      d.IntervalDuration.String(),
+		}
+		if d.TimeoutDuration > 0 {
+			check.Timeout = d.TimeoutDuration.String()
+		}
+		if d.DeregisterCriticalServiceAfterDuration > 0 {
+			check.DeregisterCriticalServiceAfter = d.DeregisterCriticalServiceAfterDuration.String()
+		}
+		serviceChecks = append(serviceChecks, check)
+	}
+	sort.Slice(serviceChecks, func(i, j int) bool { return serviceChecks[i].CheckID < serviceChecks[j].CheckID })
+	weights := s.Weights
+	return &api.AgentServiceRegistration{
+		Kind:              s.Kind,
+		ID:                s.ID,
+		Name:              s.Service,
+		Tags:              tags,
+		Port:              s.Port,
+		Address:           s.Address,
+		EnableTagOverride: s.EnableTagOverride,
+		Meta:              meta,
+		Weights:           &weights,
+		Checks:            serviceChecks,
+	}, nil
+}
+
+// agentClient returns a client to the Consul agent of the node running the instance
+func (tu *TagUpdater) agentClient(s *api.CatalogService) (*api.Client, error) {
+	conf := api.DefaultConfig()
+	if tu.Config != nil {
+		copied := *tu.Config
+		conf = &copied
+	}
+	port := tu.AgentPort
+	if port == 0 {
+		port = DefaultAgentPort
+	}
+	conf.Address = net.JoinHostPort(s.Address, strconv.Itoa(port))
+	return api.NewClient(conf)
+}
+
+func (tu *TagUpdater) agentRegister(s *api.CatalogService, tags []string, meta map[string]string) error {
+	agent, err := tu.agentClient(s)
+	if err != nil {
+		return err
+	}
+	services, err := agent.Agent().Services()
+	if err != nil {
+		return fmt.Errorf("unable to reach Consul agent of node %s: %w", s.Node, ExplainConsulError(err, "service:read on "+s.ServiceName))
+	}
+	service, ok := services[s.ServiceID]
+	if !ok {
+		return fmt.Errorf("service %s is not registered by the Consul agent of node %s", s.ServiceID, s.Node)
+	}
+	checks, err := agent.Agent().Checks()
+	if err != nil {
+		return fmt.Errorf("unable to read the checks of service %s from the Consul agent of node %s: %w", s.ServiceID, s.Node, ExplainConsulError(err, "node:read on "+s.Node))
+	}
+	reg, err := agentRegistration(service, checks, tags, meta)
+	if err != nil {
+		return err
+	}
+	if err := agent.Agent().ServiceRegister(reg); err != nil {
+		return fmt.Errorf("unable to register service %s on the Consul agent of node %s: %w", s.ServiceID, s.Node, ExplainConsulError(err, "service:write on "+s.ServiceName))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestCatalogCASPreservesService(t *testing.T) {
+	s := &api.CatalogService{
+		Node:                     "node1",
+		Address:                  "10.0.0.1",
+		ServiceID:                "wowza-edge-1",
+		ServiceName:              "wowza-edge",
+		ServiceTags:              []string{"image=wowza:1"},
+		ServiceMeta:              map[string]string{"version": "1"},
+		ServicePort:              1935,
+		ServiceWeights:           api.Weights{Passing: 10, Warning: 1},
+		ServiceEnableTagOverride: true,
+		ModifyIndex:              42,
+	}
+	op := catalogCAS(s, []string{"image=wowza:1", "update=wowza:2"}, s.ServiceMeta)
+	if op.Service == nil || op.Service.Verb != api.ServiceCAS || op.Service.Node != "node1" || op.Service.Service.ModifyIndex != 42 {
+		t.Error("Unexpected check-and-set operation", op.Service)
+	}
+	svc := op.Service.Service
+	if svc.ID != "wowza-edge-1" || svc.Meta["version"] != "1" || svc.Port != 1935 || svc.Weights.Passing != 10 || !svc.EnableTagOverride {
+		t.Error("Service definition should be preserved", svc)
+	}
+	if len(svc.Tags) != 2 || svc.Tags[1] != "update=wowza:2" {
+		t.Error("Service should get the new tags", svc.Tags)
+	}
+}
+
+func TestAgentRegistrationPreservesService(t *testing.T) {
+	s := &api.AgentService{
+		ID:      "wowza-edge-1",
+		Service: "wowza-edge",
+		Tags:    []string{"v1"},
+		Meta:    map[string]string{"version": "1"},
+		Port:    1935,
+		Weights: api.AgentWeights{Passing: 3, Warning: 1},
+	}
+	reg, err := agentRegistration(s, nil, []string{"v1", "update=wowza:2"}, s.Meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reg.ID != "wowza-edge-1" || reg.Name != "wowza-edge" || reg.Meta["version"] != "1" || reg.Port != 1935 || reg.Weights.Passing != 3 {
+		t.Error("Service definition should be preserved", reg)
+	}
+	if len(reg.Tags) != 2 || reg.Tags[1] != "update=wowza:2" {
+		t.Error("Service should get the new tags", reg.Tags)
+	}
+}
+
+func TestAgentRegistrationKeepsChecks(t *testing.T) {
+	s := &api.AgentService{ID: "wowza-edge-1", Service: "wowza-edge", Port: 1935}
+	checks := map[string]*api.AgentCheck{
+		"service:wowza-edge-1": {
+			CheckID:   "service:wowza-edge-1",
+			Name:      "Wowza HTTP",
+			Status:    api.HealthPassing,
+			ServiceID: "wowza-edge-1",
+			Definition: api.HealthCheckDefinition{
+				HTTP:             "http://10.0.0.1:8086/",
+				IntervalDuration: 10 * time.Second,
+				TimeoutDuration:  2 * time.Second,
+			},
+		},
+		"rtmp": {
+			CheckID:    "rtmp",
+			Status:     api.HealthCritical,
+			ServiceID:  "wowza-edge-1",
+			Definition: api.HealthCheckDefinition{TCP: "10.0.0.1:1935", IntervalDuration: 5 * time.Second},
+		},
+		"service:wowza-edge-2": {
+			CheckID:    "service:wowza-edge-2",
+			ServiceID:  "wowza-edge-2",
+			Definition: api.HealthCheckDefinition{TCP: "10.0.0.1:1936", IntervalDuration: 5 * time.Second},
+		},
+	}
+	reg, err := agentRegistration(s, checks, []string{"update=wowza:2"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Checks) != 2 {
+		t.Fatal("Only the checks of wowza-edge-1 should be registered", reg.Checks)
+	}
+	if c := reg.Checks[0]; c.CheckID != "rtmp" || c.TCP != "10.0.0.1:1935" || c.Interval != "5s" || c.Status != api.HealthCritical {
+		t.Error("Unexpected TCP check", c)
+	}
+	if c := reg.Checks[1]; c.HTTP != "http://10.0.0.1:8086/" || c.Interval != "10s" || c.Timeout != "2s" || c.Name != "Wowza HTTP" {
+		t.Error("Unexpected HTTP check", c)
+	}
+
+	checks["ttl"] = &api.AgentCheck{CheckID: "ttl", ServiceID: "wowza-edge-1"}
+	if _, err := agentRegistration(s, checks, nil, nil); err == nil {
+		t.Error("A TTL check can't be registered again")
+	}
+}
+
+func TestTagUpdaterStopsWhenContextIsDone(t *testing.T) {
+	c, err := api.NewClient(&api.Config{Address: "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cs := &CatalogService{Dc: "dc1", Cs: &api.CatalogService{Node: "node1", ServiceID: "wowza-edge-1", ServiceName: "wowza-edge"}}
+	err = defaultTagUpdater.Update(ctx, c, cs, func(tags *TagSet, meta map[string]string) (bool, error) {
+		t.Error("Nothing should be written once the context is done")
+		return false, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Error("The update should stop with the context", err)
+	}
+}
@@ -216,6 +216,40 @@ func TestServiceDeleteTagUnexisting(t *testing.T) {
 	}
 }
 
+func TestServiceSetTagReplacesKey(t *testing.T) {
+	catalog, server, client := initializeConsul(t)
+	defer server.Stop()
+	catalogServices, _, err := catalog.Service("wowza-edge", "", nil)
+	if err != nil {
+		t.Error("Error while retrieving services")
+	}
+	cs := CatalogService{Cs: catalogServices[0]}
+	tag := Tag{
+		Key:   "master",
+		Value: "titi",
+	}
+	cs.ServiceSetTag(client, catalogServices[0], tag)
+
+	if !cs.HasTag(tag) || cs.HasTag(Tag{Key: "master", Value: "toto"}) {
+		t.Errorf("Service should only have tag master=titi, has %s", cs.Cs.ServiceTags)
+	}
+}
+
+func TestServiceDeleteTagKey(t *testing.T) {
+	catalog, server, client := initializeConsul(t)
+	defer server.Stop()
+	catalogServices, _, err := catalog.Service("wowza-edge", "", nil)
+	if err != nil {
+		t.Error("Error while retrieving services")
+	}
+	cs := CatalogService{Cs: catalogServices[0]}
+	cs.ServiceDeleteTagKey(client, catalogServices[0], "master")
+
+	if _, ok := TagSet(cs.Cs.ServiceTags).Get("master"); ok || !cs.HasTag(Tag{Key: "v1"}) {
+		t.Errorf("Service should only have tag v1, has %s", cs.Cs.ServiceTags)
+	}
+}
+
 func TestSearchServiceWithoutTag(t *testing.T) {
 	catalog, server, _ := initializeConsul(t)
 	defer server.Stop()
@@ -0,0 +1,62 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ErrWaitTimeout is returned when the catalog does not reach the expected state before the deadline
+var ErrWaitTimeout = errors.New("timed out waiting for the Consul catalog")
+
+// nextIndex returns the index of the next blocking query, reset when the Consul index went backwards
+func nextIndex(previous uint64, last uint64) uint64 {
+	if last < previous {
+		return 0
+	}
+	return last
+}
+
+// findInstance returns the catalog entry of the instance s among services
+func findInstance(services []*api.CatalogService, s *api.CatalogService) *api.CatalogService {
+	for _, current := range services {
+		if current.Node == s.Node && current.ServiceID == s.ServiceID {
+			return current
+		}
+	}
+	return nil
+}
+
+// WaitService blocks on the catalog entries of a service until done accepts them or the deadline
+// passes. The first query returns at once, the next ones return as soon as the entries change.
+func WaitService(ctx context.Context, c *api.Client, service string, q *api.QueryOptions, deadline time.Time, done func([]*api.CatalogService) bool) ([]*api.CatalogService, error) {
+	var index uint64
+	for {
+		opts := *q
+		opts.WaitIndex = index
+		opts.WaitTime = time.Until(deadline)
+		if opts.WaitTime <= 0 {
+			if index != 0 {
+				return nil, ErrWaitTimeout
+			}
+			opts.WaitTime = 0
+		}
+		services, meta, err := c.Catalog().Service(service, "", opts.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, ExplainConsulError(err, "service:read on "+service)
+		}
+		if done(services) {
+			return services, nil
+		}
+		index = nextIndex(index, meta.LastIndex)
+		if index == 0 {
+			// Consul indexes start at 1, a zero index would never block
+			index = 1
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestNextIndex(t *testing.T) {
+	if nextIndex(0, 42) != 42 || nextIndex(42, 42) != 42 || nextIndex(42, 57) != 57 {
+		t.Error("The index should follow Consul")
+	}
+	if nextIndex(57, 12) != 0 {
+		t.Error("An index going backwards should be reset")
+	}
+}
+
+func TestFindInstance(t *testing.T) {
+	services := []*api.CatalogService{
+		{Node: "node1", ServiceID: "wowza-edge:1935"},
+		{Node: "node1", ServiceID: "wowza-edge:1936"},
+		{Node: "node2", ServiceID: "wowza-edge:1935"},
+	}
+	if s := findInstance(services, &api.CatalogService{Node: "node1", ServiceID: "wowza-edge:1936"}); s != services[1] {
+		t.Error("Unexpected instance", s)
+	}
+	if s := findInstance(services, &api.CatalogService{Node: "node3", ServiceID: "wowza-edge:1935"}); s != nil {
+		t.Error("node3 has no instance", s)
+	}
+}
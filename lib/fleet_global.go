@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/ssh"
+	"github.com/coreos/fleet/unit"
+	sdunit "github.com/coreos/go-systemd/unit"
+)
+
+// A global unit runs on every machine and fleet can't stop it on a single one. It is rolled
+// by stopping its systemd unit on the drained machine and starting in its place an instance
+// of the service template pinned on that machine. Once every machine runs a pinned instance
+// the global unit is destroyed.
+
+// GlobalUnitName returns the name of the global unit of a service
+func GlobalUnitName(serviceName string) string {
+	return fmt.Sprintf("%s.service", serviceName)
+}
+
+// IsGlobalUnitFile reports whether a unit file on disk describes a global unit
+func IsGlobalUnitFile(file string) (bool, error) {
+	uf, err := getUnitFromFile(file)
+	if err != nil {
+		return false, err
+	}
+	for _, o := range uf.Options {
+		if o.Section == "X-Fleet" && o.Name == "Global" && o.Value == "true" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// FindGlobalUnit returns the global unit of a service from the registry units, nil if there is none
+func FindGlobalUnit(units []*schema.Unit, serviceName string) *schema.Unit {
+	for _, u := range units {
+		if u.Name == GlobalUnitName(serviceName) && suToGlobal(*u) {
+			return u
+		}
+	}
+	return nil
+}
+
+// PinnedInstanceName returns the name of the template instance pinned on a machine
+func PinnedInstanceName(serviceName string, m machine.MachineState) string {
+	return fmt.Sprintf("%s@%s.service", serviceName, m.ShortID())
+}
+
+// pinUnitFile returns a copy of a global unit file scheduled on a single machine
+func pinUnitFile(uf *unit.UnitFile, machineID string) *unit.UnitFile {
+	var options []*sdunit.UnitOption
+	for _, o := range uf.Options {
+		if o.Section == "X-Fleet" && (o.Name == "Global" || o.Name == "MachineID" || o.Name == "MachineMetadata") {
+			continue
+		}
+		options = append(options, o)
+	}
+	options = append(options, &sdunit.UnitOption{Section: "X-Fleet", Name: "MachineID", Value: machineID})
+	return unit.NewUnitFromOptions(options)
+}
+
+// StopGlobalUnitOnMachine stops the systemd unit of a global unit on a single machine.
+// fleet keeps the unit launched in its registry and running on the other machines.
+func StopGlobalUnitOnMachine(sshUsername string, sshHost string, m machine.MachineState, unitName string) error {
+	sshClient, err := ssh.NewTunnelledSSHClient(sshUsername, sshHost, m.PublicIP, nil, true, getTimeout(30))
+	if err != nil {
+		return fmt.Errorf("failed initializing SSH client to %s: %v", m.PublicIP, err)
+	}
+	defer sshClient.Close()
+	log.Debugf("Stopping Unit(%s) on machine %s", unitName, m.ID)
+	err, _ = ssh.Execute(sshClient, fmt.Sprintf("sudo systemctl stop %s", unitName))
+	return err
+}
+
+// StartPinnedUnit creates from the global unit file an instance pinned on a machine and starts it
+func StartPinnedUnit(name string, file string, m machine.MachineState, cAPI *client.API, opts UnitOptions) error {
+	uf, err := getUnitFromFile(file)
+	if err != nil {
+		return fmt.Errorf("error reading unit file %s: %v", file, err)
+	}
+	if _, err := createUnit(name, pinUnitFile(uf, m.ID), cAPI); err != nil {
+		return err
+	}
+	return RunStartUnit([]string{name}, cAPI, opts)
+}
+
+// GlobalUnitMigrated reports whether every machine running the global unit also runs a pinned instance
+func GlobalUnitMigrated(serviceName string, units []*schema.Unit, states []*schema.UnitState, machines []machine.MachineState) bool {
+	pinned := make(map[string]bool)
+	for _, u := range units {
+		if IsTemplateInstance(u.Name, TemplateName(serviceName)) {
+			pinned[u.Name] = true
+		}
+	}
+	for _, s := range states {
+		if s.Name != GlobalUnitName(serviceName) {
+			continue
+		}
+		migrated := false
+		for _, m := range machines {
+			if m.ID == s.MachineID && pinned[PinnedInstanceName(serviceName, m)] {
+				migrated = true
+			}
+		}
+		if !migrated {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/unit"
+)
+
+func TestIsGlobalUnitFile(t *testing.T) {
+	path := writeUnit(t, wowzaUnit+"\n[X-Fleet]\nGlobal=true\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	global, err := IsGlobalUnitFile(path)
+	if err != nil || !global {
+		t.Error("Unit file should be global", err)
+	}
+
+	path = writeUnit(t, wowzaUnit)
+	defer os.RemoveAll(filepath.Dir(path))
+	if global, _ := IsGlobalUnitFile(path); global {
+		t.Error("Unit file should not be global")
+	}
+}
+
+func TestPinUnitFile(t *testing.T) {
+	uf, err := unit.NewUnitFile(wowzaUnit + "\n[X-Fleet]\nGlobal=true\nMachineMetadata=role=edge\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := pinUnitFile(uf, "1a2b3c4d5e6f")
+	for _, o := range pinned.Options {
+		if o.Section == "X-Fleet" && o.Name != "MachineID" {
+			t.Error("Pinned unit should not keep option", o.Name)
+		}
+	}
+	if pinned.Contents["X-Fleet"]["MachineID"][0] != "1a2b3c4d5e6f" {
+		t.Error("Pinned unit should be scheduled on machine 1a2b3c4d5e6f")
+	}
+}
+
+func TestGlobalUnitMigrated(t *testing.T) {
+	machines := fleetMachines[:2]
+	states := []*schema.UnitState{
+		{Name: "wowza-edge.service", MachineID: machines[0].ID},
+		{Name: "wowza-edge.service", MachineID: machines[1].ID},
+	}
+	units := []*schema.Unit{
+		{Name: PinnedInstanceName("wowza-edge", machines[0]), MachineID: machines[0].ID},
+	}
+	if GlobalUnitMigrated("wowza-edge", units, states, machines) {
+		t.Error("Global unit still runs alone on second machine")
+	}
+	units = append(units, &schema.Unit{Name: PinnedInstanceName("wowza-edge", machines[1]), MachineID: machines[1].ID})
+	if !GlobalUnitMigrated("wowza-edge", units, states, machines) {
+		t.Error("Every machine runs a pinned instance")
+	}
+}
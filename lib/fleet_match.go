@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/fleet/schema"
+	"github.com/coreos/fleet/unit"
+	"github.com/hashicorp/consul/api"
+)
+
+// Rules mapping a Consul service instance to a fleet unit instance
+const (
+	InstanceByMachine = "machine"
+	InstanceByPort    = "port"
+	InstanceByNode    = "node"
+	InstanceByTag     = "tag"
+)
+
+// InstanceRule tells which fleet template instance runs a given Consul service instance
+type InstanceRule struct {
+	Kind   string
+	TagKey string
+}
+
+// ParseInstanceRule builds an InstanceRule from machine, port, node or tag:<key>
+func ParseInstanceRule(s string) (InstanceRule, error) {
+	switch {
+	case s == InstanceByMachine || s == InstanceByPort || s == InstanceByNode:
+		return InstanceRule{Kind: s}, nil
+	case strings.HasPrefix(s, InstanceByTag+":") && len(s) > len(InstanceByTag)+1:
+		return InstanceRule{Kind: InstanceByTag, TagKey: s[len(InstanceByTag)+1:]}, nil
+	}
+	return InstanceRule{}, fmt.Errorf("unknown instance rule %q, expected machine, port, node or tag:<key>", s)
+}
+
+// Instance returns the fleet instance identifier of a Consul service instance.
+// It returns false when the rule does not constrain the instance.
+func (r InstanceRule) Instance(cs *api.CatalogService) (string, bool, error) {
+	switch r.Kind {
+	case InstanceByPort:
+		return strconv.Itoa(cs.ServicePort), true, nil
+	case InstanceByNode:
+		return cs.Node, true, nil
+	case InstanceByTag:
+		if t, ok := TagSet(cs.ServiceTags).Get(r.TagKey); ok && t.Value != "" {
+			return t.Value, true, nil
+		}
+		return "", true, fmt.Errorf("service %s on node %s has no tag %s", cs.ServiceName, cs.Node, r.TagKey)
+	}
+	return "", false, nil
+}
+
+// TemplateName returns the fleet template unit name of a service
+func TemplateName(serviceName string) string {
+	return fmt.Sprintf("%s@.service", serviceName)
+}
+
+// IsTemplateInstance reports whether unitName is an instance of the template unit
+func IsTemplateInstance(unitName string, template string) bool {
+	info := unit.NewUnitNameInfo(unitName)
+	return info != nil && info.IsInstance() && info.Template == template
+}
+
+// FindServiceUnits returns the instances of template running on machineID that match the
+// Consul service instance according to rule
+func FindServiceUnits(units []*schema.Unit, template string, machineID string, cs *api.CatalogService, rule InstanceRule) ([]*schema.Unit, error) {
+	instance, constrained, err := rule.Instance(cs)
+	if err != nil {
+		return nil, err
+	}
+	var found []*schema.Unit
+	for _, u := range units {
+		if u.MachineID != machineID || !IsTemplateInstance(u.Name, template) {
+			continue
+		}
+		if constrained && unit.NewUnitNameInfo(u.Name).Instance != instance {
+			continue
+		}
+		found = append(found, u)
+	}
+	return found, nil
+}
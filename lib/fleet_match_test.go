@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/coreos/fleet/schema"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestParseInstanceRule(t *testing.T) {
+	rule, err := ParseInstanceRule("tag:instance")
+	if err != nil || rule.Kind != InstanceByTag || rule.TagKey != "instance" {
+		t.Error("tag:instance should be parsed as a tag rule on key instance", rule, err)
+	}
+	if _, err := ParseInstanceRule("tag:"); err == nil {
+		t.Error("tag rule without key should be refused")
+	}
+	if _, err := ParseInstanceRule("ip"); err == nil {
+		t.Error("unknown rule should be refused")
+	}
+}
+
+func TestIsTemplateInstance(t *testing.T) {
+	template := TemplateName("wowza-origin")
+	if !IsTemplateInstance("wowza-origin@1.service", template) {
+		t.Error("wowza-origin@1.service is an instance of", template)
+	}
+	for _, name := range []string{"wowza-origin-old@1.service", "wowza-origin@.service", "wowza-origin.service", "wowza-originXservice"} {
+		if IsTemplateInstance(name, template) {
+			t.Error(name, "is not an instance of", template)
+		}
+	}
+}
+
+func TestFindServiceUnits(t *testing.T) {
+	units := []*schema.Unit{
+		{Name: "wowza-origin@1935.service", MachineID: "m1"},
+		{Name: "wowza-origin@1936.service", MachineID: "m1"},
+		{Name: "wowza-origin-old@1935.service", MachineID: "m1"},
+		{Name: "wowza-origin@1935.service", MachineID: "m2"},
+	}
+	cs := &api.CatalogService{ServiceName: "wowza-origin", Node: "node1", ServicePort: 1935, ServiceTags: []string{"instance=1936"}}
+	template := TemplateName("wowza-origin")
+
+	found, _ := FindServiceUnits(units, template, "m1", cs, InstanceRule{Kind: InstanceByMachine})
+	if len(found) != 2 {
+		t.Error("machine rule should match both instances of m1, found", len(found))
+	}
+	found, _ = FindServiceUnits(units, template, "m1", cs, InstanceRule{Kind: InstanceByPort})
+	if len(found) != 1 || found[0].Name != "wowza-origin@1935.service" {
+		t.Error("port rule should match wowza-origin@1935.service only, found", found)
+	}
+	found, _ = FindServiceUnits(units, template, "m1", cs, InstanceRule{Kind: InstanceByTag, TagKey: "instance"})
+	if len(found) != 1 || found[0].Name != "wowza-origin@1936.service" {
+		t.Error("tag rule should match wowza-origin@1936.service only, found", found)
+	}
+	if _, err := FindServiceUnits(units, template, "m1", cs, InstanceRule{Kind: InstanceByTag, TagKey: "missing"}); err == nil {
+		t.Error("tag rule should fail when service has no such tag")
+	}
+}
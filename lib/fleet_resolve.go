@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/hashicorp/consul/api"
+)
+
+// Strategies to find the fleet machine running a Consul service instance
+const (
+	MachineByIP       = "ip"
+	MachineByLAN      = "lan"
+	MachineByWAN      = "wan"
+	MachineByNode     = "node"
+	MachineByID       = "machine-id"
+	MachineByMetadata = "metadata"
+
+	// DefaultMachineIDKey is the node meta or service tag key publishing the fleet machine ID
+	DefaultMachineIDKey = "fleet-machine-id"
+)
+
+// MachineResolver finds the fleet machine running a Consul service instance
+type MachineResolver struct {
+	Kind string
+	Key  string
+}
+
+// ParseMachineResolver builds a MachineResolver from ip, lan, wan, node, machine-id[:<key>] or metadata:<key>
+func ParseMachineResolver(s string) (MachineResolver, error) {
+	kind, key := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, key = s[:i], s[i+1:]
+	}
+	switch kind {
+	case MachineByIP, MachineByLAN, MachineByWAN, MachineByNode:
+		if key == "" {
+			return MachineResolver{Kind: kind}, nil
+		}
+	case MachineByID:
+		if key == "" {
+			key = DefaultMachineIDKey
+		}
+		return MachineResolver{Kind: kind, Key: key}, nil
+	case MachineByMetadata:
+		if key != "" {
+			return MachineResolver{Kind: kind, Key: key}, nil
+		}
+	}
+	return MachineResolver{}, fmt.Errorf("unknown machine resolver %q, expected ip, lan, wan, node, machine-id[:<key>] or metadata:<key>", s)
+}
+
+func shortHostname(name string) string {
+	return strings.SplitN(name, ".", 2)[0]
+}
+
+// machineID returns the fleet machine ID published by a service instance in its node meta or tags
+func (r MachineResolver) machineID(cs *api.CatalogService) string {
+	if id, ok := cs.NodeMeta[r.Key]; ok {
+		return id
+	}
+	t, _ := TagSet(cs.ServiceTags).Get(r.Key)
+	return t.Value
+}
+
+func (r MachineResolver) match(m machine.MachineState, cs *api.CatalogService) bool {
+	switch r.Kind {
+	case MachineByIP:
+		return m.PublicIP != "" && (m.PublicIP == cs.Address || m.PublicIP == cs.ServiceAddress)
+	case MachineByLAN, MachineByWAN:
+		return m.PublicIP != "" && m.PublicIP == cs.TaggedAddresses[r.Kind]
+	case MachineByNode:
+		return m.Metadata["hostname"] != "" && shortHostname(m.Metadata["hostname"]) == shortHostname(cs.Node)
+	case MachineByID:
+		id := r.machineID(cs)
+		// fleetctl displays truncated machine IDs, accept them as published value
+		return id != "" && strings.HasPrefix(m.ID, id)
+	case MachineByMetadata:
+		return m.Metadata[r.Key] != "" && m.Metadata[r.Key] == cs.Node
+	}
+	return false
+}
+
+// Resolve returns the single fleet machine running the service instance
+func (r MachineResolver) Resolve(machines []machine.MachineState, cs *api.CatalogService) (machine.MachineState, error) {
+	var found []machine.MachineState
+	for _, m := range machines {
+		if r.match(m, cs) {
+			found = append(found, m)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return machine.MachineState{}, fmt.Errorf("no fleet machine found for service %s on node %s with resolver %s", cs.ServiceName, cs.Node, r)
+	case 1:
+		return found[0], nil
+	}
+	var ids []string
+	for _, m := range found {
+		ids = append(ids, m.ID)
+	}
+	return machine.MachineState{}, fmt.Errorf("service %s on node %s matches %d fleet machines with resolver %s: %s", cs.ServiceName, cs.Node, len(found), r, strings.Join(ids, ", "))
+}
+
+func (r MachineResolver) String() string {
+	if r.Key == "" {
+		return r.Kind
+	}
+	return fmt.Sprintf("%s:%s", r.Kind, r.Key)
+}
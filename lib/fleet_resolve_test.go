@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/hashicorp/consul/api"
+)
+
+var fleetMachines = []machine.MachineState{
+	{ID: "1a2b3c4d5e6f", PublicIP: "10.0.0.1", Metadata: map[string]string{"hostname": "coreos1.botsunit.io", "role": "edge"}},
+	{ID: "9f8e7d6c5b4a", PublicIP: "10.0.0.2", Metadata: map[string]string{"hostname": "coreos2.botsunit.io", "role": "edge"}},
+	{ID: "0a0b0c0d0e0f", PublicIP: "10.0.0.2", Metadata: map[string]string{"hostname": "coreos3.botsunit.io", "role": "origin"}},
+}
+
+func TestParseMachineResolver(t *testing.T) {
+	r, err := ParseMachineResolver("machine-id")
+	if err != nil || r.Key != DefaultMachineIDKey {
+		t.Error("machine-id should default to key", DefaultMachineIDKey, r, err)
+	}
+	for _, s := range []string{"metadata", "ip:foo", "mac"} {
+		if _, err := ParseMachineResolver(s); err == nil {
+			t.Error(s, "should be refused")
+		}
+	}
+}
+
+func TestResolveMachine(t *testing.T) {
+	cs := &api.CatalogService{
+		ServiceName:     "wowza-edge",
+		Node:            "coreos1",
+		Address:         "192.168.0.1",
+		TaggedAddresses: map[string]string{"lan": "192.168.0.1", "wan": "10.0.0.1"},
+		NodeMeta:        map[string]string{"fleet-machine-id": "1a2b3c4d"},
+	}
+	for _, s := range []string{"wan", "node", "machine-id"} {
+		r, _ := ParseMachineResolver(s)
+		m, err := r.Resolve(fleetMachines, cs)
+		if err != nil || m.ID != "1a2b3c4d5e6f" {
+			t.Error(s, "should resolve machine 1a2b3c4d5e6f, got", m.ID, err)
+		}
+	}
+	r, _ := ParseMachineResolver("ip")
+	if _, err := r.Resolve(fleetMachines, cs); err == nil {
+		t.Error("ip should not resolve a machine for a private address")
+	}
+}
+
+func TestResolveMachineAmbiguous(t *testing.T) {
+	cs := &api.CatalogService{ServiceName: "wowza-edge", Node: "coreos2", Address: "10.0.0.2"}
+	r, _ := ParseMachineResolver("ip")
+	if _, err := r.Resolve(fleetMachines, cs); err == nil {
+		t.Error("Should fail because two machines share the same public IP")
+	}
+	r, _ = ParseMachineResolver("metadata:hostname")
+	cs.Node = "coreos2.botsunit.io"
+	m, err := r.Resolve(fleetMachines, cs)
+	if err != nil || m.ID != "9f8e7d6c5b4a" {
+		t.Error("metadata:hostname should resolve machine 9f8e7d6c5b4a, got", m.ID, err)
+	}
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/hashicorp/consul/api"
+)
+
+// MachineSelector restricts a rollout to fleet machines having all the given metadata
+type MachineSelector map[string]string
+
+// ParseMachineSelector builds a MachineSelector from a comma separated list of key=value
+func ParseMachineSelector(s string) (MachineSelector, error) {
+	selector := MachineSelector{}
+	if s == "" {
+		return selector, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		x := strings.SplitN(kv, "=", 2)
+		if len(x) != 2 || x[0] == "" {
+			return nil, fmt.Errorf("invalid machine selector %q, expected key=value", kv)
+		}
+		selector[x[0]] = x[1]
+	}
+	return selector, nil
+}
+
+// Match reports whether the machine has all the metadata of the selector
+func (sel MachineSelector) Match(m machine.MachineState) bool {
+	for k, v := range sel {
+		if m.Metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectServices keeps the service instances running on machines matched by selector.
+func SelectServices(services []*api.CatalogService, machines []machine.MachineState, resolver MachineResolver, selector MachineSelector) []*api.CatalogService {
+	var selected []*api.CatalogService
+	for _, s := range services {
+		m, err := resolver.Resolve(machines, s)
+		if err != nil {
+			// an instance not mapped to a machine can't be told apart, the update reports it later
+			if len(selector) == 0 {
+				selected = append(selected, s)
+			}
+			continue
+		}
+		if !selector.Match(m) {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	return selected
+}
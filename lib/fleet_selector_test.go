@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/hashicorp/consul/api"
+)
+
+func TestParseMachineSelector(t *testing.T) {
+	sel, err := ParseMachineSelector("role=edge,zone=a")
+	if err != nil || len(sel) != 2 || sel["role"] != "edge" || sel["zone"] != "a" {
+		t.Error("Unexpected selector", sel, err)
+	}
+	if _, err := ParseMachineSelector("role"); err == nil {
+		t.Error("Selector without value should be refused")
+	}
+}
+
+func TestSelectServicesByMetadata(t *testing.T) {
+	machines := []machine.MachineState{
+		{ID: "1a2b3c4d5e6f", PublicIP: "10.0.0.1", Metadata: map[string]string{"role": "edge", "zone": "b"}},
+		{ID: "9f8e7d6c5b4a", PublicIP: "10.0.0.2", Metadata: map[string]string{"role": "edge", "zone": "a"}},
+	}
+	services := []*api.CatalogService{
+		{ServiceName: "wowza-edge", Node: "coreos1", Address: "10.0.0.1"},
+		{ServiceName: "wowza-edge", Node: "coreos2", Address: "10.0.0.2"},
+		{ServiceName: "wowza-edge", Node: "coreos9", Address: "10.0.0.9"},
+	}
+	resolver := MachineResolver{Kind: MachineByIP}
+
+	selected := SelectServices(services, machines, resolver, MachineSelector{"role": "edge"})
+	if len(selected) != 2 {
+		t.Fatal("Unresolved instance should not be selected, got", len(selected))
+	}
+	if selected[0].Node != "coreos1" || selected[1].Node != "coreos2" {
+		t.Error("Catalog order should be kept, got", selected[0].Node, selected[1].Node)
+	}
+
+	selected = SelectServices(services, machines, resolver, MachineSelector{"role": "origin"})
+	if len(selected) != 0 {
+		t.Error("No instance runs on an origin machine, got", len(selected))
+	}
+}
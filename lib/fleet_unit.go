@@ -1,10 +1,11 @@
 package lib
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math"
 	"os"
 	"path"
 	"sync"
@@ -25,37 +26,96 @@ const (
 
 var (
 	machineStates map[string]*machine.MachineState
-	// flags used by multiple commands
-	sharedFlags = struct {
-		Sign          bool
-		Full          bool
-		NoLegend      bool
-		NoBlock       bool
-		Replace       bool
-		BlockAttempts int
-		Fields        string
-		SSHPort       int
-	}{}
+
+	// ErrUnitTimeout is returned when units do not reach the expected state in time
+	ErrUnitTimeout = errors.New("timed out waiting for units")
+	// ErrUnitFailed is returned when systemd reports a unit failed and won't become active by itself
+	ErrUnitFailed = errors.New("unit failed")
 )
 
+// UnitOptions tells how start and destroy operations block on fleet
+type UnitOptions struct {
+	// Context bounds the whole operation, reaching its deadline returns ErrUnitTimeout
+	Context context.Context
+	// BlockAttempts is the number of fleet states to check before giving up. Zero
+	// checks until the context is done and a negative value does not wait at all.
+	BlockAttempts int
+	// PollInterval is the delay between two fetches of fleet states
+	PollInterval time.Duration
+	// Watcher fetches fleet states, the updater shares one across the rollout. When nil
+	// each operation starts its own.
+	Watcher *UnitStateWatcher
+}
+
+func (o UnitOptions) context() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// withWatcher returns options holding a started watcher and the function releasing it
+func (o UnitOptions) withWatcher(cAPI *client.API) (UnitOptions, func()) {
+	if o.Watcher != nil {
+		return o, func() {}
+	}
+	o.Watcher = NewUnitStateWatcher(cAPI, o.PollInterval)
+	o.Watcher.Start()
+	return o, o.Watcher.Stop
+}
+
+// watch calls check on every snapshot of the watcher until it succeeds or fails,
+// attempts are exhausted or the context is done
+func (o UnitOptions) watch(check func(UnitSnapshot) (bool, error)) error {
+	snapshots, unsubscribe := o.Watcher.Subscribe()
+	defer unsubscribe()
+	ctx := o.context()
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrUnitTimeout
+			}
+			return ctx.Err()
+		case snap := <-snapshots:
+			if snap.Err == nil {
+				ok, err := check(snap)
+				if err != nil {
+					return err
+				}
+				if ok {
+					return nil
+				}
+			}
+		}
+		if o.BlockAttempts > 0 && attempt >= o.BlockAttempts {
+			return ErrUnitTimeout
+		}
+	}
+}
+
+// IsUnitTimeout reports whether err comes from units not reaching their state in time
+func IsUnitTimeout(err error) bool {
+	return errors.Is(err, ErrUnitTimeout)
+}
+
 //RunStartUnit allow to start unit
-func RunStartUnit(args []string, cAPI *client.API) (exit int) {
+func RunStartUnit(args []string, cAPI *client.API, opts UnitOptions) error {
 	if len(args) == 0 {
 		fmt.Println("No units given")
-		return 0
+		return nil
 	}
 
-	if err := lazyCreateUnits(args, cAPI); err != nil {
-		fmt.Printf("Error creating units: %v", err)
-		fmt.Println()
-		return 1
+	opts, release := opts.withWatcher(cAPI)
+	defer release()
+
+	if err := lazyCreateUnits(args, opts, cAPI); err != nil {
+		return fmt.Errorf("error creating units: %w", err)
 	}
 
 	triggered, err := lazyStartUnits(args, cAPI)
 	if err != nil {
-		fmt.Printf("Error starting units: %v", err)
-		fmt.Println()
-		return 1
+		return fmt.Errorf("error starting units: %w", err)
 	}
 
 	var starting []string
@@ -68,86 +128,63 @@ func RunStartUnit(args []string, cAPI *client.API) (exit int) {
 		}
 	}
 
-	if err := tryWaitForUnitStates(starting, "start", job.JobStateLaunched, getBlockAttempts(), os.Stdout, cAPI); err != nil {
-		fmt.Printf("Error waiting for unit states, exit status: %v", err)
-		fmt.Println()
-		return 1
+	if err := tryWaitForUnitStates(starting, "start", job.JobStateLaunched, opts, os.Stdout, cAPI); err != nil {
+		return fmt.Errorf("error waiting for unit states: %w", err)
 	}
 
-	if err := tryWaitForSystemdActiveState(starting, getBlockAttempts(), cAPI); err != nil {
-		fmt.Printf("Error waiting for systemd unit states, err: %v", err)
-		fmt.Println()
-		return 1
+	if err := tryWaitForSystemdActiveState(starting, opts, cAPI); err != nil {
+		return fmt.Errorf("error waiting for systemd unit states: %w", err)
 	}
 
-	return 0
+	return nil
 }
 
 // RunDestroyUnit allow to destroy a unit
-func RunDestroyUnit(args []string, cAPI *client.API) (exit int) {
+func RunDestroyUnit(args []string, cAPI *client.API, opts UnitOptions) (err error) {
 	if len(args) == 0 {
 		fmt.Println("No units given")
-		return 0
+		return nil
 	}
 
 	units, err := findUnits(args, cAPI)
 	if err != nil {
-		fmt.Printf("%v", err)
-		fmt.Println()
-		return 1
+		return err
 	}
 
 	if len(units) == 0 {
 		fmt.Println("Units not found in registry")
-		return 0
+		return nil
 	}
 
+	opts, release := opts.withWatcher(cAPI)
+	defer release()
+
 	for _, v := range units {
-		err := (*cAPI).DestroyUnit(v.Name)
-		if err != nil {
+		errD := (*cAPI).DestroyUnit(v.Name)
+		if errD != nil {
 			// Ignore 'Unit does not exist' error
-			if client.IsErrorUnitNotFound(err) {
+			if client.IsErrorUnitNotFound(errD) {
 				continue
 			}
-			fmt.Printf("Error destroying units: %v", err)
-			fmt.Println()
-			exit = 1
+			err = fmt.Errorf("error destroying unit %s: %w", v.Name, errD)
 			continue
 		}
 
-		if sharedFlags.NoBlock {
-			attempts := sharedFlags.BlockAttempts
-			retry := func() bool {
-				if sharedFlags.BlockAttempts < 1 {
-					return true
-				}
-				attempts--
-				if attempts == 0 {
-					return false
-				}
-				return true
-			}
-
-			for retry() {
-				u, err := (*cAPI).Unit(v.Name)
-				if err != nil {
-					fmt.Printf("Error destroying units: %v", err)
-					fmt.Println()
-					exit = 1
-					break
-				}
-
-				if u == nil {
-					break
-				}
-				time.Sleep(defaultSleepTime)
+		if opts.BlockAttempts >= 0 {
+			name := v.Name
+			errW := opts.watch(func(snap UnitSnapshot) (bool, error) {
+				return snap.Units[name] == nil, nil
+			})
+			if errW != nil {
+				err = fmt.Errorf("error waiting for unit %s destruction: %w", name, errW)
+				continue
 			}
 		}
 
 		fmt.Printf("Destroyed %s", v.Name)
 		fmt.Println()
 	}
-	return
+	return err
 }
 
 func findUnits(args []string, cAPI *client.API) (sus []schema.Unit, err error) {
@@ -353,8 +390,8 @@ func suToGlobal(su schema.Unit) bool {
 	return u.IsGlobal()
 }
 
-func lazyCreateUnits(args []string, cAPI *client.API) error {
-	errchan := make(chan error)
+func lazyCreateUnits(args []string, opts UnitOptions, cAPI *client.API) error {
+	errchan := make(chan error, len(args))
 	var wg sync.WaitGroup
 	for _, arg := range args {
 		arg = maybeAppendDefaultUnitType(arg)
@@ -381,7 +418,7 @@ func lazyCreateUnits(args []string, cAPI *client.API) error {
 		}
 
 		wg.Add(1)
-		go checkUnitState(name, job.JobStateInactive, 0, os.Stdout, &wg, errchan, cAPI)
+		go checkUnitState(name, job.JobStateInactive, opts, os.Stdout, &wg, errchan, cAPI)
 	}
 
 	go func() {
@@ -389,15 +426,15 @@ func lazyCreateUnits(args []string, cAPI *client.API) error {
 		close(errchan)
 	}()
 
-	haserr := false
+	var errW error
 	for msg := range errchan {
 		fmt.Printf("Error waiting on unit creation: %v", msg)
 		fmt.Println()
-		haserr = true
+		errW = msg
 	}
 
-	if haserr {
-		return fmt.Errorf("One or more errors creating units")
+	if errW != nil {
+		return fmt.Errorf("One or more errors creating units: %w", errW)
 	}
 
 	return nil
@@ -435,11 +472,11 @@ func createUnit(name string, uf *unit.UnitFile, cAPI *client.API) (*schema.Unit,
 }
 
 // tryWaitForSystemdActiveState tries to wait for systemd units to reach an
-// active state, making use of cAPI. It takes one or more units as input, and
+// active state, making use of the watcher of opts. It takes one or more units as input, and
 // ensures that every unit in the []units must be in the active state.
 // If yes, return nil. Otherwise return error.
-func tryWaitForSystemdActiveState(units []string, maxAttempts int, cAPI *client.API) (err error) {
-	if maxAttempts <= -1 {
+func tryWaitForSystemdActiveState(units []string, opts UnitOptions, cAPI *client.API) (err error) {
+	if opts.BlockAttempts <= -1 {
 		for _, name := range units {
 			fmt.Printf("Triggered unit %s start", name)
 			fmt.Println()
@@ -447,7 +484,7 @@ func tryWaitForSystemdActiveState(units []string, maxAttempts int, cAPI *client.
 		return nil
 	}
 
-	errchan := waitForSystemdActiveState(units, maxAttempts, cAPI)
+	errchan := waitForSystemdActiveState(units, opts, cAPI)
 	for err := range errchan {
 		fmt.Printf("Error waiting for units: %v", err)
 		fmt.Println()
@@ -457,68 +494,38 @@ func tryWaitForSystemdActiveState(units []string, maxAttempts int, cAPI *client.
 	return nil
 }
 
-func checkSystemdActiveState(apiStates []*schema.UnitState, name string, maxAttempts int, wg *sync.WaitGroup, errchan chan error, cAPI *client.API) {
+func checkSystemdActiveState(name string, opts UnitOptions, wg *sync.WaitGroup, errchan chan error, cAPI *client.API) {
 	defer wg.Done()
 
-	// "isInf == true" means "blocking forever until it succeeded".
-	// In that case, maxAttempts is set to an arbitrary large integer number.
-	var isInf bool
-	if maxAttempts < 1 {
-		isInf = true
-		maxAttempts = math.MaxInt32
-	}
-
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		if err := assertFetchSystemdActiveState(apiStates, name, cAPI); err == nil {
-			return
-		} else {
-			errchan <- err
-		}
-
-		if !isInf {
-			errchan <- fmt.Errorf("timed out waiting for unit %s to report active state", name)
+	// Every snapshot of the watcher holds fresh unit states, the assertion is
+	// evaluated again on each of them until the unit is active or failed.
+	err := opts.watch(func(snap UnitSnapshot) (bool, error) {
+		err := assertSystemdActiveState(snap.States, name)
+		if errors.Is(err, ErrUnitFailed) {
+			return false, err
 		}
+		return err == nil, nil
+	})
+	if err != nil {
+		errchan <- fmt.Errorf("unit %s did not report active state: %w", name, err)
 	}
 }
 
-func assertFetchSystemdActiveState(apiStates []*schema.UnitState, name string, cAPI *client.API) error {
-	if err := assertSystemdActiveState(apiStates, name); err == nil {
-		return nil
-	}
-
-	// If the assertion failed, we again need to get unit states via cAPI,
-	// to retry the assertion repeatedly.
-	//
-	// NOTE: Ideally we should be able to fetch the state only for a single
-	// unit. However, we cannot do that for now, because cAPI.UnitState()
-	// is not available. In the future we need to implement cAPI.UnitState()
-	// and all dependendent parts all over the tree in fleet, (schema,
-	// etcdRegistry, rpcRegistry, etc) to replace UnitStates() in this place
-	// with the new method UnitState(). In practice, calling UnitStates() here
-	// is not as badly inefficient as it looks, because it will be anyway
-	// rarely called only when the assertion failed. - dpark 20160907
-
-	time.Sleep(defaultSleepTime)
-
-	var errU error
-	apiStates, errU = (*cAPI).UnitStates()
-	if errU != nil {
-		return fmt.Errorf("Error retrieving list of units: %v", errU)
-	}
-	return nil
-}
-
 // assertSystemdActiveState determines if a given systemd unit is actually
-// in the active state, making use of cAPI
+// in the active state. A unit in the failed state is reported with ErrUnitFailed.
 func assertSystemdActiveState(apiStates []*schema.UnitState, unitName string) error {
 	uState, err := getSingleUnitState(apiStates, unitName)
 	if err != nil {
 		return err
 	}
 
+	if uState.ActiveState == "failed" {
+		return fmt.Errorf("%w: %s is %s/%s/%s", ErrUnitFailed, unitName, uState.LoadState, uState.ActiveState, uState.SubState)
+	}
+
 	// Get systemd state and check the state is active & loaded.
 	if uState.ActiveState != "active" || uState.LoadState != "loaded" {
-		return fmt.Errorf("Failed to find an active unit %s", unitName)
+		return fmt.Errorf("Failed to find an active unit %s, state is %s/%s/%s", unitName, uState.LoadState, uState.ActiveState, uState.SubState)
 	}
 
 	return nil
@@ -547,18 +554,12 @@ type suState struct {
 
 // waitForSystemdActiveState tries to assert that the given unit becomes
 // active, making use of multiple goroutines that check unit states.
-func waitForSystemdActiveState(units []string, maxAttempts int, cAPI *client.API) (errch chan error) {
-	apiStates, err := (*cAPI).UnitStates()
-	if err != nil {
-		errch <- fmt.Errorf("Error retrieving list of units: %v", err)
-		return
-	}
-
-	errchan := make(chan error)
+func waitForSystemdActiveState(units []string, opts UnitOptions, cAPI *client.API) chan error {
+	errchan := make(chan error, len(units))
 	var wg sync.WaitGroup
 	for _, name := range units {
 		wg.Add(1)
-		go checkSystemdActiveState(apiStates, name, maxAttempts, &wg, errchan, cAPI)
+		go checkSystemdActiveState(name, opts, &wg, errchan, cAPI)
 	}
 
 	go func() {
@@ -569,28 +570,19 @@ func waitForSystemdActiveState(units []string, maxAttempts int, cAPI *client.API
 	return errchan
 }
 
-// getBlockAttempts gets the correct value of how many attempts to try
-// before giving up on an operation.
-// It returns a negative value which means do not block, if zero is
-// returned then it means try forever, and if a positive value is
-// returned then try up to that value
-func getBlockAttempts() int {
-	return 0
-}
-
 // tryWaitForUnitStates tries to wait for units to reach the desired state.
 // It takes 5 arguments, the units to wait for, the desired state, the
-// desired JobState, how many attempts before timing out and a writer
+// desired JobState, the options telling how long to block and a writer
 // interface.
 // tryWaitForUnitStates polls each of the indicated units until they
-// reach the desired state. If maxAttempts is negative, then it will not
+// reach the desired state. If opts.BlockAttempts is negative, then it will not
 // wait, it will assume that all units reached their desired state.
-// If maxAttempts is zero tryWaitForUnitStates will retry forever, and
+// If it is zero tryWaitForUnitStates will retry until opts.Context is done, and
 // if it is greater than zero, it will retry up to the indicated value.
 // It returns nil on success or error on failure.
-func tryWaitForUnitStates(units []string, state string, js job.JobState, maxAttempts int, out io.Writer, cAPI *client.API) error {
+func tryWaitForUnitStates(units []string, state string, js job.JobState, opts UnitOptions, out io.Writer, cAPI *client.API) error {
 	// We do not wait just assume we reached the desired state
-	if maxAttempts <= -1 {
+	if opts.BlockAttempts <= -1 {
 		for _, name := range units {
 			fmt.Printf("Triggered unit %s %s", name, state)
 			fmt.Println()
@@ -598,7 +590,7 @@ func tryWaitForUnitStates(units []string, state string, js job.JobState, maxAtte
 		return nil
 	}
 
-	errchan := waitForUnitStates(units, js, maxAttempts, out, cAPI)
+	errchan := waitForUnitStates(units, js, opts, out, cAPI)
 	for err := range errchan {
 		fmt.Printf("Error waiting for units: %v", err)
 		fmt.Println()
@@ -608,37 +600,21 @@ func tryWaitForUnitStates(units []string, state string, js job.JobState, maxAtte
 	return nil
 }
 
-func checkUnitState(name string, js job.JobState, maxAttempts int, out io.Writer, wg *sync.WaitGroup, errchan chan error, cAPI *client.API) {
+func checkUnitState(name string, js job.JobState, opts UnitOptions, out io.Writer, wg *sync.WaitGroup, errchan chan error, cAPI *client.API) {
 	defer wg.Done()
 
-	sleep := defaultSleepTime
-
-	if maxAttempts < 1 {
-		for {
-			if assertUnitState(name, js, out, cAPI) {
-				return
-			}
-			time.Sleep(sleep)
-		}
-	} else {
-		for attempt := 0; attempt < maxAttempts; attempt++ {
-			if assertUnitState(name, js, out, cAPI) {
-				return
-			}
-			time.Sleep(sleep)
-		}
-		errchan <- fmt.Errorf("timed out waiting for unit %s to report state %s", name, js)
+	err := opts.watch(func(snap UnitSnapshot) (bool, error) {
+		return assertUnitState(snap, name, js, out, cAPI), nil
+	})
+	if err != nil {
+		errchan <- fmt.Errorf("unit %s did not report state %s: %w", name, js, err)
 	}
 }
 
-func assertUnitState(name string, js job.JobState, out io.Writer, cAPI *client.API) (ret bool) {
+func assertUnitState(snap UnitSnapshot, name string, js job.JobState, out io.Writer, cAPI *client.API) (ret bool) {
 	var state string
 
-	u, err := (*cAPI).Unit(name)
-	if err != nil {
-		log.Warningf("Error retrieving Unit(%s) from Registry: %v", name, err)
-		return
-	}
+	u := snap.Units[name]
 	if u == nil {
 		log.Warningf("Unit %s not found", name)
 		return
@@ -705,17 +681,15 @@ func cachedMachineState(machID string, cAPI *client.API) (ms *machine.MachineSta
 
 // waitForUnitStates polls each of the indicated units until each of their
 // states is equal to that which the caller indicates, or until the
-// polling operation times out. waitForUnitStates will retry forever, or
-// up to maxAttempts times before timing out if maxAttempts is greater
-// than zero. Returned is an error channel used to communicate when
-// timeouts occur. The returned error channel will be closed after all
-// polling operation is complete.
-func waitForUnitStates(units []string, js job.JobState, maxAttempts int, out io.Writer, cAPI *client.API) chan error {
-	errchan := make(chan error)
+// polling operation times out according to opts. Returned is an error
+// channel used to communicate when timeouts occur. The returned error
+// channel will be closed after all polling operation is complete.
+func waitForUnitStates(units []string, js job.JobState, opts UnitOptions, out io.Writer, cAPI *client.API) chan error {
+	errchan := make(chan error, len(units))
 	var wg sync.WaitGroup
 	for _, name := range units {
 		wg.Add(1)
-		go checkUnitState(name, js, maxAttempts, out, &wg, errchan, cAPI)
+		go checkUnitState(name, js, opts, out, &wg, errchan, cAPI)
 	}
 
 	go func() {
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/coreos/fleet/schema"
+)
+
+// publishSnapshots starts a watcher publishing snap every millisecond without fetching fleet
+func publishSnapshots(snap UnitSnapshot) *UnitStateWatcher {
+	w := NewUnitStateWatcher(nil, time.Millisecond)
+	w.fetchSnapshot = func() UnitSnapshot { return snap }
+	w.Start()
+	return w
+}
+
+func TestWatchStopsAfterBlockAttempts(t *testing.T) {
+	w := publishSnapshots(UnitSnapshot{})
+	defer w.Stop()
+	calls := 0
+	opts := UnitOptions{BlockAttempts: 3, Watcher: w}
+	err := opts.watch(func(UnitSnapshot) (bool, error) {
+		calls++
+		return false, nil
+	})
+	if !IsUnitTimeout(err) {
+		t.Error("watch should time out after 3 attempts, returned", err)
+	}
+	if calls != 3 {
+		t.Error("check should have been called 3 times, called", calls)
+	}
+}
+
+func TestWatchStopsOnContextDeadline(t *testing.T) {
+	w := publishSnapshots(UnitSnapshot{})
+	defer w.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	opts := UnitOptions{Context: ctx, Watcher: w}
+	err := opts.watch(func(UnitSnapshot) (bool, error) { return false, nil })
+	if !IsUnitTimeout(err) {
+		t.Error("watch should time out on context deadline, returned", err)
+	}
+}
+
+func TestWatchSharesSnapshotsBetweenUnits(t *testing.T) {
+	w := publishSnapshots(UnitSnapshot{Units: map[string]*schema.Unit{
+		"wowza-edge@1.service": {Name: "wowza-edge@1.service", CurrentState: "launched"},
+	}})
+	defer w.Stop()
+	opts := UnitOptions{Watcher: w, BlockAttempts: 10}
+	for _, name := range []string{"wowza-edge@1.service", "wowza-edge@2.service"} {
+		err := opts.watch(func(snap UnitSnapshot) (bool, error) {
+			return snap.Units[name] != nil, nil
+		})
+		if name == "wowza-edge@1.service" && err != nil {
+			t.Error(name, "is in the snapshot", err)
+		}
+		if name == "wowza-edge@2.service" && !IsUnitTimeout(err) {
+			t.Error(name, "is not in the snapshot", err)
+		}
+	}
+}
+
+func TestWatcherOnlyPublishesFetchesStartedAfterSubscription(t *testing.T) {
+	w := NewUnitStateWatcher(nil, time.Hour)
+	fetched := make(chan uint64)
+	stale := make(chan struct{})
+	w.fetchSnapshot = func() UnitSnapshot {
+		w.mu.Lock()
+		fetch := w.fetches
+		w.mu.Unlock()
+		if fetch == 1 {
+			// a second operation subscribes while the first fetch is running
+			fetched <- fetch
+			<-stale
+		}
+		return UnitSnapshot{Units: map[string]*schema.Unit{"fetch": {Name: fmt.Sprint(fetch)}}}
+	}
+	_, unsubscribeFirst := w.Subscribe()
+	defer unsubscribeFirst()
+	w.Start()
+	defer w.Stop()
+	<-fetched
+	second, unsubscribeSecond := w.Subscribe()
+	defer unsubscribeSecond()
+	close(stale)
+	if snap := <-second; snap.Units["fetch"].Name != "2" {
+		t.Error("The second subscriber should skip the fetch started before it, got", snap.Units["fetch"].Name)
+	}
+}
+
+func TestAssertSystemdActiveState(t *testing.T) {
+	states := []*schema.UnitState{
+		{Name: "active.service", SystemdLoadState: "loaded", SystemdActiveState: "active", SystemdSubState: "running"},
+		{Name: "activating.service", SystemdLoadState: "loaded", SystemdActiveState: "activating", SystemdSubState: "start-pre"},
+		{Name: "failed.service", SystemdLoadState: "loaded", SystemdActiveState: "failed", SystemdSubState: "failed"},
+	}
+	if err := assertSystemdActiveState(states, "active.service"); err != nil {
+		t.Error("active.service should be active", err)
+	}
+	if err := assertSystemdActiveState(states, "activating.service"); err == nil || IsUnitTimeout(err) {
+		t.Error("activating.service should not be active yet", err)
+	}
+	if err := assertSystemdActiveState(states, "failed.service"); !errors.Is(err, ErrUnitFailed) {
+		t.Error("failed.service should be reported failed", err)
+	}
+}
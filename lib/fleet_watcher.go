@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"sync"
+	"time"
+
+	"github.com/coreos/fleet/client"
+	"github.com/coreos/fleet/log"
+	"github.com/coreos/fleet/schema"
+)
+
+// UnitSnapshot is the state of the fleet registry fetched by a UnitStateWatcher
+type UnitSnapshot struct {
+	// Units are the registry units by name
+	Units map[string]*schema.Unit
+	// States are the systemd states of units on every machine
+	States []*schema.UnitState
+	// Err is set when the registry could not be fetched
+	Err error
+}
+
+// UnitStateWatcher fetches units and unit states from fleet once per interval and
+// publishes each snapshot to every subscriber, so that waiting on many units
+// does not multiply the requests sent to fleet. It only fetches fleet while someone
+// is subscribed, one watcher can be shared by a whole rollout.
+type UnitStateWatcher struct {
+	cAPI     *client.API
+	interval time.Duration
+	// fetchSnapshot reads fleet, replaced by tests
+	fetchSnapshot func() UnitSnapshot
+
+	mu sync.Mutex
+	// subscribers hold the number of fetches started when they subscribed
+	subscribers map[chan UnitSnapshot]uint64
+	fetches     uint64
+	wake        chan struct{}
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewUnitStateWatcher creates a watcher fetching fleet every interval, it has to be started
+func NewUnitStateWatcher(cAPI *client.API, interval time.Duration) *UnitStateWatcher {
+	if interval <= 0 {
+		interval = defaultSleepTime
+	}
+	w := &UnitStateWatcher{
+		cAPI:        cAPI,
+		interval:    interval,
+		subscribers: make(map[chan UnitSnapshot]uint64),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+	w.fetchSnapshot = w.fetch
+	return w
+}
+
+// Start runs the watcher until Stop is called
+func (w *UnitStateWatcher) Start() {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			if fetch, ok := w.begin(); ok {
+				w.publish(fetch, w.fetchSnapshot())
+			}
+			select {
+			case <-w.stop:
+				return
+			case <-w.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops fetching fleet
+func (w *UnitStateWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+// Subscribe returns a channel receiving the latest snapshot and a function to unsubscribe.
+// Only snapshots fetched after the subscription are received, a state left over from
+// before an operation can't satisfy its wait.
+func (w *UnitStateWatcher) Subscribe() (<-chan UnitSnapshot, func()) {
+	ch := make(chan UnitSnapshot, 1)
+	w.mu.Lock()
+	w.subscribers[ch] = w.fetches
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return ch, func() {
+		w.mu.Lock()
+		delete(w.subscribers, ch)
+		w.mu.Unlock()
+	}
+}
+
+// begin numbers the next fetch, there is none to do without subscribers
+func (w *UnitStateWatcher) begin() (uint64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.subscribers) == 0 {
+		return 0, false
+	}
+	w.fetches++
+	return w.fetches, true
+}
+
+func (w *UnitStateWatcher) fetch() UnitSnapshot {
+	units, err := (*w.cAPI).Units()
+	if err != nil {
+		log.Warningf("Error retrieving list of units: %v", err)
+		return UnitSnapshot{Err: err}
+	}
+	states, err := (*w.cAPI).UnitStates()
+	if err != nil {
+		log.Warningf("Error retrieving list of unit states: %v", err)
+		return UnitSnapshot{Err: err}
+	}
+	snap := UnitSnapshot{Units: make(map[string]*schema.Unit, len(units)), States: states}
+	for _, u := range units {
+		snap.Units[u.Name] = u
+	}
+	return snap
+}
+
+// publish hands the snapshot of a fetch to the subscribers which subscribed before it started,
+// replacing one they did not consume yet
+func (w *UnitStateWatcher) publish(fetch uint64, snap UnitSnapshot) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch, subscribed := range w.subscribers {
+		if subscribed >= fetch {
+			continue
+		}
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- snap
+	}
+}
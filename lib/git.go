@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// GitRepository is the git working tree holding the fleet unit files
+type GitRepository struct {
+	Dir string
+}
+
+func (g GitRepository) run(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", g.Dir}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed in %s: %v: %s", strings.Join(args, " "), g.Dir, err, strings.TrimSpace(string(out)))
+	}
+	// only trailing newlines are trimmed, porcelain lines start with a space for unstaged changes
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// HeadCommit returns the commit hash the working tree is at
+func (g GitRepository) HeadCommit() (string, error) {
+	return g.run("rev-parse", "HEAD")
+}
+
+// DirtyFiles returns the files which are modified or untracked in the working tree
+func (g GitRepository) DirtyFiles() ([]string, error) {
+	out, err := g.run("status", "--porcelain", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) > 3 {
+			files = append(files, line[3:])
+		}
+	}
+	return files, nil
+}
+
+// CheckClean ensures the working tree has no pending change and returns the commit it is at
+func (g GitRepository) CheckClean() (string, error) {
+	files, err := g.DirtyFiles()
+	if err != nil {
+		return "", err
+	}
+	if len(files) > 0 {
+		return "", fmt.Errorf("units directory %s has uncommitted changes: %s", g.Dir, strings.Join(files, ", "))
+	}
+	return g.HeadCommit()
+}
+
+// Commit commits the given files with message and returns the new commit hash
+func (g GitRepository) Commit(message string, files ...string) (string, error) {
+	if _, err := g.run(append([]string{"add", "--"}, files...)...); err != nil {
+		return "", err
+	}
+	if _, err := g.run("commit", "-m", message); err != nil {
+		return "", err
+	}
+	return g.HeadCommit()
+}
+
+// ShortCommit truncates a commit hash to the length git usually displays
+func ShortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
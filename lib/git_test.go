@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func initGitRepository(t *testing.T) GitRepository {
+	dir, err := ioutil.TempDir("", "units")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	repo := GitRepository{Dir: dir}
+	if _, err := repo.run("init"); err != nil {
+		t.Skip("git is not available :", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "wowza-origin@.service"), []byte(wowzaUnit), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Commit("Add wowza origin", "wowza-origin@.service"); err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestGitCheckCleanReturnsHeadCommit(t *testing.T) {
+	repo := initGitRepository(t)
+	defer os.RemoveAll(repo.Dir)
+
+	commit, err := repo.CheckClean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, _ := repo.HeadCommit()
+	if commit == "" || commit != head {
+		t.Error("CheckClean should return HEAD commit and returned", commit)
+	}
+}
+
+func TestGitCheckCleanFailsOnDirtyTree(t *testing.T) {
+	repo := initGitRepository(t)
+	defer os.RemoveAll(repo.Dir)
+
+	RewriteUnitImage(filepath.Join(repo.Dir, "wowza-origin@.service"), "eu.gcr.io/scalezen/wowza_bundle:0.3.4")
+	if _, err := repo.CheckClean(); err == nil {
+		t.Error("CheckClean should fail because unit file is modified")
+	}
+
+	before, _ := repo.HeadCommit()
+	after, err := repo.Commit("Update wowza-origin", "wowza-origin@.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before {
+		t.Error("Commit should have created a new commit")
+	}
+	if _, err := repo.CheckClean(); err != nil {
+		t.Error("Working tree should be clean after commit :", err)
+	}
+}
+
+func TestGitDirtyFilesKeepsUnstagedNames(t *testing.T) {
+	repo := initGitRepository(t)
+	defer os.RemoveAll(repo.Dir)
+
+	RewriteUnitImage(filepath.Join(repo.Dir, "wowza-origin@.service"), "eu.gcr.io/scalezen/wowza_bundle:0.3.4")
+	files, err := repo.DirtyFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0] != "wowza-origin@.service" {
+		t.Error("Unexpected dirty files", files)
+	}
+}
@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Health policies telling how instances with failing checks are selected
+const (
+	// HealthFailingFirst updates instances with failing checks, before healthy ones with the catalog
+	// strategy
+	HealthFailingFirst = "first"
+	// HealthSkipFailing never updates instances with failing checks
+	HealthSkipFailing = "skip"
+	// HealthIgnore selects instances regardless of their checks
+	HealthIgnore = "ignore"
+)
+
+// ErrNoOutdatedService is returned when every instance selectable runs the update image
+var ErrNoOutdatedService = errors.New("no outdated instance left")
+
+// ErrMinHealthy is returned when updating the next instance would leave too few passing instances
+var ErrMinHealthy = errors.New("not enough passing instances")
+
+// ParseHealthPolicy checks a health policy given on the command line
+func ParseHealthPolicy(s string) (string, error) {
+	switch s {
+	case HealthFailingFirst, HealthSkipFailing, HealthIgnore:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown health policy %q, expected first, skip or ignore", s)
+}
+
+// ServiceHealth is the aggregated status of the checks of every instance of a service
+type ServiceHealth map[string]string
+
+func instanceKey(node string, serviceID string) string {
+	return node + "/" + serviceID
+}
+
+// checksStatus aggregates the status of checks, the worst status wins
+func checksStatus(checks api.HealthChecks) string {
+	status := api.HealthPassing
+	for _, c := range checks {
+		switch c.Status {
+		case api.HealthMaint:
+			return api.HealthMaint
+		case api.HealthCritical:
+			status = api.HealthCritical
+		case api.HealthWarning:
+			if status == api.HealthPassing {
+				status = api.HealthWarning
+			}
+		}
+	}
+	return status
+}
+
+// NewServiceHealth indexes the health entries of a service by node and service ID
+func NewServiceHealth(entries []*api.ServiceEntry) ServiceHealth {
+	health := ServiceHealth{}
+	for _, e := range entries {
+		if e.Node == nil || e.Service == nil {
+			continue
+		}
+		health[instanceKey(e.Node.Node, e.Service.ID)] = checksStatus(e.Checks)
+	}
+	return health
+}
+
+// GetServiceHealth reads the checks of every instance of a service from the Consul health API
+func GetServiceHealth(client *api.Client, service string, q *api.QueryOptions) (ServiceHealth, error) {
+	entries, _, err := client.Health().Service(service, "", false, q)
+	if err != nil {
+		return nil, ExplainConsulError(err, "service:read on "+service+" and node:read")
+	}
+	return NewServiceHealth(entries), nil
+}
+
+// Status returns the aggregated status of an instance, an instance unknown to the health API is critical
+func (h ServiceHealth) Status(s *api.CatalogService) string {
+	status, ok := h[instanceKey(s.Node, s.ServiceID)]
+	if !ok {
+		return api.HealthCritical
+	}
+	return status
+}
+
+// Passing reports whether every check of an instance passes
+func (h ServiceHealth) Passing(s *api.CatalogService) bool {
+	return h.Status(s) == api.HealthPassing
+}
+
+// PassingCount returns the number of instances of the service whose checks all pass
+func (h ServiceHealth) PassingCount() int {
+	n := 0
+	for _, status := range h {
+		if status == api.HealthPassing {
+			n++
+		}
+	}
+	return n
+}
+
+// Failing returns the instances whose checks do not all pass
+func (h ServiceHealth) Failing(services []*api.CatalogService) []*api.CatalogService {
+	var failing []*api.CatalogService
+	for _, s := range services {
+		if !h.Passing(s) {
+			failing = append(failing, s)
+		}
+	}
+	return failing
+}
+
+// SelectNextService picks the first instance of services without imageTag that the health policy
+// lets update, services are expected in the order of the selection strategy. An instance with
+// passing checks is only taken down when at least minHealthy instances of the whole service remain
+// passing, ErrMinHealthy is returned otherwise. A failing instance then stands in, taken from the
+// zone of the first candidate before the later zones, zones may be nil.
+func SelectNextService(services []*api.CatalogService, imageTag Tag, health ServiceHealth, policy string, minHealthy int, zones map[string]string) (*api.CatalogService, error) {
+	var candidates []*api.CatalogService
+	for _, s := range services {
+		cs := CatalogService{Cs: s}
+		if cs.HasLabel(imageTag) {
+			continue
+		}
+		if policy == HealthSkipFailing && !health.Passing(s) {
+			continue
+		}
+		candidates = append(candidates, s)
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoOutdatedService
+	}
+	passing := health.PassingCount()
+	if !health.Passing(candidates[0]) || passing-1 >= minHealthy {
+		return candidates[0], nil
+	}
+	zone := zones[instanceKey(candidates[0].Node, candidates[0].ServiceID)]
+	for _, s := range candidates {
+		if !health.Passing(s) && zones[instanceKey(s.Node, s.ServiceID)] == zone {
+			return s, nil
+		}
+	}
+	for _, s := range candidates {
+		if !health.Passing(s) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d passing, at least %d required after taking one down", ErrMinHealthy, passing, minHealthy)
+}
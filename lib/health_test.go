@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func healthEntry(node string, statuses ...string) *api.ServiceEntry {
+	e := &api.ServiceEntry{Node: &api.Node{Node: node}, Service: &api.AgentService{ID: "wowza-edge"}}
+	for _, status := range statuses {
+		e.Checks = append(e.Checks, &api.HealthCheck{Node: node, Status: status})
+	}
+	return e
+}
+
+func healthServices(nodes ...string) []*api.CatalogService {
+	var services []*api.CatalogService
+	for _, n := range nodes {
+		services = append(services, &api.CatalogService{Node: n, ServiceID: "wowza-edge", ServiceTags: []string{"image=wowza:1"}})
+	}
+	return services
+}
+
+func TestServiceHealthStatus(t *testing.T) {
+	health := NewServiceHealth([]*api.ServiceEntry{
+		healthEntry("node1", api.HealthPassing, api.HealthPassing),
+		healthEntry("node2", api.HealthPassing, api.HealthWarning),
+		healthEntry("node3", api.HealthCritical, api.HealthWarning),
+		healthEntry("node4", api.HealthCritical, api.HealthMaint),
+	})
+	services := healthServices("node1", "node2", "node3", "node4", "node5")
+	expected := []string{api.HealthPassing, api.HealthWarning, api.HealthCritical, api.HealthMaint, api.HealthCritical}
+	for i, s := range services {
+		if health.Status(s) != expected[i] {
+			t.Error("Unexpected status of", s.Node, health.Status(s))
+		}
+	}
+	if health.PassingCount() != 1 || len(health.Failing(services)) != 4 {
+		t.Error("Only node1 passes", health.PassingCount(), len(health.Failing(services)))
+	}
+}
+
+func TestSelectNextServiceHealthPolicy(t *testing.T) {
+	health := NewServiceHealth([]*api.ServiceEntry{
+		healthEntry("node1", api.HealthPassing),
+		healthEntry("node2", api.HealthCritical),
+		healthEntry("node3", api.HealthPassing),
+	})
+	services := healthServices("node1", "node2", "node3")
+	image := Tag{Key: "image", Value: "wowza:2"}
+
+	s, err := SelectNextService(services, image, health, HealthFailingFirst, 0, nil)
+	if err != nil || s.Node != "node1" {
+		t.Error("The order is left to the strategy", s, err)
+	}
+	s, err = SelectNextService(services, image, health, HealthSkipFailing, 0, nil)
+	if err != nil || s.Node != "node1" {
+		t.Error("Failing instance should be skipped", s, err)
+	}
+	s, err = SelectNextService(services, image, health, HealthIgnore, 0, nil)
+	if err != nil || s.Node != "node1" {
+		t.Error("Catalog order should be kept", s, err)
+	}
+
+	services[0].ServiceTags = []string{"image=wowza:2"}
+	services[2].ServiceTags = []string{"image=wowza:2"}
+	if _, err := SelectNextService(services, image, health, HealthSkipFailing, 0, nil); err != ErrNoOutdatedService {
+		t.Error("Only a failing instance is outdated, nothing to update when skipped", err)
+	}
+}
+
+func TestSelectNextServiceMinHealthy(t *testing.T) {
+	health := NewServiceHealth([]*api.ServiceEntry{
+		healthEntry("node1", api.HealthPassing),
+		healthEntry("node2", api.HealthPassing),
+		healthEntry("node3", api.HealthCritical),
+	})
+	services := healthServices("node1", "node2", "node3")
+	image := Tag{Key: "image", Value: "wowza:2"}
+
+	if _, err := SelectNextService(services[:2], image, health, HealthIgnore, 2, nil); !errors.Is(err, ErrMinHealthy) {
+		t.Error("Taking down a passing instance would leave 1 passing instance", err)
+	}
+	s, err := SelectNextService(services, image, health, HealthIgnore, 2, nil)
+	if err != nil || s.Node != "node3" {
+		t.Error("A failing instance can be taken down without lowering passing instances", s, err)
+	}
+	if s, err := SelectNextService(services[:2], image, health, HealthIgnore, 1, nil); err != nil || s.Node != "node1" {
+		t.Error("One passing instance is left", s, err)
+	}
+}
+
+func TestSelectNextServiceMinHealthyKeepsZone(t *testing.T) {
+	health := NewServiceHealth([]*api.ServiceEntry{
+		healthEntry("node1", api.HealthPassing),
+		healthEntry("node2", api.HealthCritical),
+		healthEntry("node3", api.HealthCritical),
+		healthEntry("node4", api.HealthPassing),
+	})
+	// zones alternate as with the zone round robin strategy
+	services := healthServices("node1", "node3", "node2", "node4")
+	zones := map[string]string{
+		instanceKey("node1", "wowza-edge"): "a",
+		instanceKey("node2", "wowza-edge"): "a",
+		instanceKey("node3", "wowza-edge"): "b",
+		instanceKey("node4", "wowza-edge"): "b",
+	}
+	image := Tag{Key: "image", Value: "wowza:2"}
+
+	s, err := SelectNextService(services, image, health, HealthIgnore, 2, zones)
+	if err != nil || s.Node != "node2" {
+		t.Error("The failing instance of the current zone should stand in first", s, err)
+	}
+	services[2].ServiceTags = []string{"image=wowza:2"}
+	s, err = SelectNextService(services, image, health, HealthIgnore, 2, zones)
+	if err != nil || s.Node != "node3" {
+		t.Error("A failing instance of a later zone stands in once the current zone has none", s, err)
+	}
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// JournalEntry is a rollout event recorded in the journal
+type JournalEntry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Rollout string    `json:"rollout,omitempty"`
+	Service string    `json:"service,omitempty"`
+	Dc      string    `json:"dc,omitempty"`
+	Node    string    `json:"node,omitempty"`
+	Unit    string    `json:"unit,omitempty"`
+	Image   string    `json:"image,omitempty"`
+	Commit  string    `json:"commit,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Journal appends rollout events as JSON lines to a file, a nil Journal records nothing
+type Journal struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewJournal returns a journal writing to path, or nil if path is empty
+func NewJournal(path string) *Journal {
+	if path == "" {
+		return nil
+	}
+	return &Journal{path: path}
+}
+
+// Record appends an entry to the journal
+func (j *Journal) Record(e JournalEntry) error {
+	if j == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// ReadJournal reads every entry of a journal file
+func ReadJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,257 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/schema"
+	"github.com/hashicorp/consul/api"
+)
+
+// ServiceRecord is a Consul service instance as listed by the list command
+type ServiceRecord struct {
+	Service            string   `json:"service"`
+	Node               string   `json:"node"`
+	Address            string   `json:"address"`
+	LAN                string   `json:"lan"`
+	WAN                string   `json:"wan"`
+	Tags               []string `json:"tags"`
+	CurrentConnections int32    `json:"current_connections"`
+	Reachable          bool     `json:"reachable"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// NewServiceRecord builds the record of a service instance with its Wowza metrics, an instance
+// whose metrics could not be fetched is unreachable. Collections are never nil so that JSON and
+// YAML keep the same shape for every instance.
+func NewServiceRecord(s *api.CatalogService, metrics MetricsResult) ServiceRecord {
+	r := ServiceRecord{
+		Service:            s.ServiceName,
+		Node:               s.Node,
+		Address:            s.Address,
+		LAN:                s.TaggedAddresses["lan"],
+		WAN:                s.TaggedAddresses["wan"],
+		Tags:               append([]string{}, s.ServiceTags...),
+		CurrentConnections: metrics.Metrics.CurrentConnections,
+		Reachable:          metrics.Err == nil,
+	}
+	if metrics.Err != nil {
+		r.Error = metrics.Err.Error()
+	}
+	return r
+}
+
+// WriteServiceList writes service instances in the given output format
+func WriteServiceList(w io.Writer, format string, records []ServiceRecord) error {
+	header := []string{"service", "node", "address", "lan", "wan", "tags", "current_connections", "error"}
+	var rows [][]string
+	for _, r := range records {
+		connections := fmt.Sprint(r.CurrentConnections)
+		if !r.Reachable {
+			connections = "unreachable"
+		}
+		rows = append(rows, []string{r.Service, r.Node, r.Address, r.LAN, r.WAN, strings.Join(r.Tags, ","), connections, r.Error})
+	}
+	return WriteListing(w, format, records, header, rows)
+}
+
+// UnitOptionRecord is an option of a fleet unit
+type UnitOptionRecord struct {
+	Section string `json:"section"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+}
+
+// UnitRecord is a fleet unit with its systemd state
+type UnitRecord struct {
+	Name         string             `json:"name"`
+	MachineID    string             `json:"machine_id"`
+	CurrentState string             `json:"current_state"`
+	DesiredState string             `json:"desired_state"`
+	LoadState    string             `json:"load_state"`
+	ActiveState  string             `json:"active_state"`
+	SubState     string             `json:"sub_state"`
+	Options      []UnitOptionRecord `json:"options"`
+}
+
+// NewUnitRecords builds the records of fleet units, global units get a record per machine
+func NewUnitRecords(units []*schema.Unit, states []*schema.UnitState) []UnitRecord {
+	records := []UnitRecord{}
+	for _, u := range units {
+		r := UnitRecord{Name: u.Name, MachineID: u.MachineID, CurrentState: u.CurrentState, DesiredState: u.DesiredState, Options: []UnitOptionRecord{}}
+		for _, o := range u.Options {
+			r.Options = append(r.Options, UnitOptionRecord{Section: o.Section, Name: o.Name, Value: o.Value})
+		}
+		found := false
+		for _, st := range states {
+			if st.Name != u.Name || (u.MachineID != "" && st.MachineID != u.MachineID) {
+				continue
+			}
+			found = true
+			sr := r
+			sr.MachineID = st.MachineID
+			sr.LoadState = st.SystemdLoadState
+			sr.ActiveState = st.SystemdActiveState
+			sr.SubState = st.SystemdSubState
+			records = append(records, sr)
+		}
+		if !found {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// WriteUnitList writes fleet units in the given output format
+func WriteUnitList(w io.Writer, format string, records []UnitRecord) error {
+	header := []string{"name", "machine_id", "current_state", "desired_state", "load_state", "active_state", "sub_state", "options"}
+	var rows [][]string
+	for _, r := range records {
+		var options []string
+		for _, o := range r.Options {
+			options = append(options, fmt.Sprintf("%s.%s=%s", o.Section, o.Name, o.Value))
+		}
+		rows = append(rows, []string{r.Name, r.MachineID, r.CurrentState, r.DesiredState, r.LoadState, r.ActiveState, r.SubState, strings.Join(options, ";")})
+	}
+	return WriteListing(w, format, records, header, rows)
+}
+
+// MachineRecord is a fleet machine with its metadata
+type MachineRecord struct {
+	ID       string            `json:"id"`
+	PublicIP string            `json:"public_ip"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// NewMachineRecords builds the records of fleet machines
+func NewMachineRecords(machines []machine.MachineState) []MachineRecord {
+	records := []MachineRecord{}
+	for _, m := range machines {
+		metadata := m.Metadata
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		records = append(records, MachineRecord{ID: m.ID, PublicIP: m.PublicIP, Metadata: metadata})
+	}
+	return records
+}
+
+func formatMetadata(metadata map[string]string) string {
+	var kv []string
+	for k, v := range metadata {
+		kv = append(kv, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(kv)
+	return strings.Join(kv, ",")
+}
+
+// WriteMachineList writes fleet machines in the given output format
+func WriteMachineList(w io.Writer, format string, records []MachineRecord) error {
+	header := []string{"id", "public_ip", "metadata"}
+	var rows [][]string
+	for _, r := range records {
+		rows = append(rows, []string{r.ID, r.PublicIP, formatMetadata(r.Metadata)})
+	}
+	return WriteListing(w, format, records, header, rows)
+}
+
+// InstanceStatusRecord is the rollout state of a service instance
+type InstanceStatusRecord struct {
+	Node               string       `json:"node"`
+	Address            string       `json:"address"`
+	State              string       `json:"state"`
+	Health             string       `json:"health"`
+	Rollout            string       `json:"rollout,omitempty"`
+	CurrentConnections int32        `json:"current_connections"`
+	Reachable          *bool        `json:"reachable,omitempty"` // omitted when the connections were not measured
+	Units              []UnitStatus `json:"units"`
+}
+
+// RolloutStatusRecord is the rollout state of a service
+type RolloutStatusRecord struct {
+	Service    string                 `json:"service"`
+	Dc         string                 `json:"dc"`
+	Image      string                 `json:"image"`
+	Total      int                    `json:"total"`
+	Updated    int                    `json:"updated"`
+	Progress   float64                `json:"progress"`
+	ETASeconds int64                  `json:"eta_seconds"`
+	Instances  []InstanceStatusRecord `json:"instances"`
+}
+
+// State returns whether the instance is updated, outdated or in which phase of its update it is
+func (i InstanceStatus) State() string {
+	if i.Updated {
+		return "updated"
+	} else if i.Draining {
+		if i.Phase != "" {
+			return i.Phase
+		}
+		return PhaseDraining
+	}
+	return "outdated"
+}
+
+// Record returns the rollout status with stable field names
+func (s RolloutStatus) Record() RolloutStatusRecord {
+	r := RolloutStatusRecord{
+		Service:    s.Service,
+		Dc:         s.Dc,
+		Image:      s.Image,
+		Total:      len(s.Instances),
+		Updated:    s.Updated(),
+		Progress:   s.Progress(),
+		ETASeconds: int64(s.ETA / time.Second),
+		Instances:  []InstanceStatusRecord{},
+	}
+	for _, i := range s.Instances {
+		record := InstanceStatusRecord{
+			Node:               i.Node,
+			Address:            i.Address,
+			State:              i.State(),
+			Health:             i.Health,
+			Rollout:            i.Rollout,
+			CurrentConnections: i.CurrentConnections,
+			Units:              append([]UnitStatus{}, i.Units...),
+		}
+		if i.Measured {
+			reachable := i.MetricsError == nil
+			record.Reachable = &reachable
+		}
+		r.Instances = append(r.Instances, record)
+	}
+	return r
+}
+
+// WriteRolloutStatus writes the rollout status in the given output format, the table format
+// starts with a summary line
+func WriteRolloutStatus(w io.Writer, format string, s RolloutStatus) error {
+	record := s.Record()
+	if format == OutputTable {
+		fmt.Fprintf(w, "[%s] dc:%s image:%s updated:%d/%d progress:%.0f%%", s.Service, s.Dc, s.Image, record.Updated, record.Total, record.Progress)
+		if s.ETA > 0 {
+			fmt.Fprintf(w, " eta:%s", s.ETA.Round(time.Second))
+		}
+		fmt.Fprintln(w)
+	}
+	header := []string{"node", "address", "state", "health", "current_connections", "units"}
+	var rows [][]string
+	for _, i := range record.Instances {
+		connections := fmt.Sprint(i.CurrentConnections)
+		if i.Reachable == nil {
+			connections = "-"
+		} else if !*i.Reachable {
+			connections = "unreachable"
+		}
+		var units []string
+		for _, u := range i.Units {
+			units = append(units, fmt.Sprintf("%s:%s:%s/%s", u.Name, u.CurrentState, u.ActiveState, u.SubState))
+		}
+		rows = append(rows, []string{i.Node, i.Address, i.State, i.Health, connections, strings.Join(units, ";")})
+	}
+	return WriteListing(w, format, record, header, rows)
+}
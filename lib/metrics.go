@@ -1,8 +1,12 @@
 package lib
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"sync"
+	"time"
 	"wowza-rolling-update/digest"
 )
 
@@ -13,8 +17,23 @@ type Metrics struct {
 	MaxIncommingStreams int32 `json:"maxIncommingStreams"`
 }
 
+// StatusError is returned when Wowza answers with another status than 200 OK
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "wowza returned " + e.Status
+}
+
 // GetMetrics allow to retrive wowza metrics with mock or really
 func GetMetrics(url string, transport *digest.Transport) (Metrics, error) {
+	return GetMetricsWithContext(context.Background(), url, transport)
+}
+
+// GetMetricsWithContext retrieves wowza metrics, the request is cancelled when ctx is done
+func GetMetricsWithContext(ctx context.Context, url string, transport *digest.Transport) (Metrics, error) {
 
 	var metrics Metrics
 	// initialize the client
@@ -23,12 +42,20 @@ func GetMetrics(url string, transport *digest.Transport) (Metrics, error) {
 		return metrics, err
 	}
 
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return metrics, err
+	}
+
 	// make the call (auth will happen)
-	resp, err := client.Get(url)
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
 		return metrics, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return metrics, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
 
 	// used only for debug, warning it will clear the resp.Body buffer
 	// body, err := ioutil.ReadAll(resp.Body)
@@ -42,6 +69,41 @@ func GetMetrics(url string, transport *digest.Transport) (Metrics, error) {
 	return metrics, err
 }
 
+// MetricsResult is the outcome of fetching the metrics of one Wowza server
+type MetricsResult struct {
+	Metrics Metrics
+	Err     error
+}
+
+// CollectMetrics fetches the metrics of every url with at most concurrency requests in flight,
+// each request is bounded by timeout. Results are in the order of urls.
+func CollectMetrics(ctx context.Context, urls []string, transport *digest.Transport, concurrency int, timeout time.Duration) []MetricsResult {
+	results := make([]MetricsResult, len(urls))
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			reqCtx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				reqCtx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			metrics, err := GetMetricsWithContext(reqCtx, url, transport)
+			results[i] = MetricsResult{Metrics: metrics, Err: err}
+		}(i, url)
+	}
+	wg.Wait()
+	return results
+}
+
 func fakeMain() {
 	// setup a transport to handle disgest
 	transport := digest.NewTransport("admin", "admin.123")
@@ -1,10 +1,12 @@
 package lib
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"wowza-rolling-update/digest"
 )
@@ -66,3 +68,35 @@ func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	response.Body = ioutil.NopCloser(strings.NewReader(responseBody))
 	return response, nil
 }
+
+type slowTransport struct{ delay time.Duration }
+
+func (t *slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Host, "slow") {
+		select {
+		case <-time.After(t.delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return (&mockTransport{}).RoundTrip(req)
+}
+
+func TestCollectMetricsTimeout(t *testing.T) {
+	transport := &digest.Transport{Username: "admin", Password: "toto", Transport: &slowTransport{delay: time.Minute}}
+	urls := []string{"http://edge1/wowza", "http://slow/wowza", "http://edge2/wowza"}
+	start := time.Now()
+	results := CollectMetrics(context.Background(), urls, transport, 2, 50*time.Millisecond)
+	if time.Since(start) > 5*time.Second {
+		t.Error("A slow server should not block the collection")
+	}
+	if len(results) != 3 {
+		t.Fatal("Expected a result per url, got", len(results))
+	}
+	if results[0].Err != nil || results[0].Metrics.CurrentConnections != 45 || results[2].Err != nil {
+		t.Error("Reachable servers should report their metrics", results)
+	}
+	if results[1].Err == nil {
+		t.Error("The slow server should time out")
+	}
+}
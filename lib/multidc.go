@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// ErrSoakFailed is returned when a datacenter does not stay healthy during its soak period
+var ErrSoakFailed = errors.New("datacenter did not stay healthy")
+
+// MultiDCRollout rolls a service datacenter after datacenter. Once a datacenter is finished, the
+// instances its rollout did not leave alone have to keep passing checks during Soak before the next
+// one starts.
+type MultiDCRollout struct {
+	// Base is the updater copied for every datacenter with its name and fleet endpoint
+	Base        Updater
+	Datacenters []DatacenterConfig
+	Soak        time.Duration
+	// SoakInterval is the delay between two health checks of a soaking datacenter
+	SoakInterval time.Duration
+}
+
+// Updater returns the updater of a datacenter
+func (m *MultiDCRollout) Updater(dc DatacenterConfig) *Updater {
+	u := m.Base
+	u.Dc = dc.Name
+	u.FleetSSHServer = dc.FleetSSHServer
+	if dc.FleetSSHUser != "" {
+		u.FleetSSHUser = dc.FleetSSHUser
+	}
+	return &u
+}
+
+// Run rolls the datacenters in order, it stops at the first datacenter failing or not staying healthy
+func (m *MultiDCRollout) Run(ctx context.Context) error {
+	if m.Base.RolloutID == "" {
+		// every datacenter shares the rollout ID
+		m.Base.RolloutID = NewRolloutID()
+	}
+	if !m.Base.SkipPreflight {
+		if err := m.preflight(ctx); err != nil {
+			return err
+		}
+		m.Base.SkipPreflight = true
+	}
+	for i, dc := range m.Datacenters {
+		log.Println("Rolling", m.Base.Service, "to", m.Base.Image, "in datacenter", dc.Name, "with fleet", dc.FleetSSHServer)
+		u := m.Updater(dc)
+		if err := u.Run(ctx); err != nil {
+			return fmt.Errorf("datacenter %s: %w", dc.Name, err)
+		}
+		if i == len(m.Datacenters)-1 {
+			break
+		}
+		if err := m.soak(ctx, u); err != nil {
+			return fmt.Errorf("datacenter %s: %w", dc.Name, err)
+		}
+	}
+	return nil
+}
+
+// preflight checks every datacenter before the first one starts
+func (m *MultiDCRollout) preflight(ctx context.Context) error {
+	var failures []string
+	for _, dc := range m.Datacenters {
+		err := m.Updater(dc).Preflight(ctx)
+		var pe *PreflightError
+		if errors.As(err, &pe) {
+			for _, f := range pe.Failures {
+				failures = append(failures, dc.Name+": "+f)
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(failures) > 0 {
+		return &PreflightError{Failures: failures}
+	}
+	return nil
+}
+
+// soak checks the datacenter during the soak period
+func (m *MultiDCRollout) soak(ctx context.Context, u *Updater) error {
+	interval := m.SoakInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	log.Println("Datacenter", u.Dc, "finished, soaking for", m.Soak)
+	u.record(JournalEntry{Event: "soak-start", Message: m.Soak.String()})
+	deadline := time.Now().Add(m.Soak)
+	for {
+		if err := u.Healthy(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			u.record(JournalEntry{Event: "soak-failed", Message: err.Error()})
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		wait := interval
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+	log.Println("Datacenter", u.Dc, "stayed healthy for", m.Soak)
+	u.record(JournalEntry{Event: "soak-passed"})
+	return nil
+}
+
+// Healthy checks that the instances selected by the updater have passing checks after its rollout
+func (u *Updater) Healthy(ctx context.Context) error {
+	q := (&api.QueryOptions{Datacenter: u.Dc}).WithContext(ctx)
+	services, _, err := u.Client.Catalog().Service(u.Service, "", q)
+	if err != nil {
+		return ExplainConsulError(err, "service:read on "+u.Service)
+	}
+	if len(u.MachineSelector) > 0 {
+		machines, err := ListFleetMachines(u.FleetSSHUser, u.FleetSSHServer)
+		if err != nil {
+			return err
+		}
+		services = SelectServices(services, machines, u.MachineResolver, u.MachineSelector)
+	}
+	health, err := GetServiceHealth(u.Client, u.Service, q)
+	if err != nil {
+		return err
+	}
+	return CheckServicesHealthy(services, health, u.report)
+}
+
+// CheckServicesHealthy checks that the instances of a finished rollout have passing checks. The
+// instances the report skipped or gave up were known to be left alone and are not checked again.
+func CheckServicesHealthy(services []*api.CatalogService, health ServiceHealth, report RolloutReport) error {
+	if len(services) == 0 {
+		return fmt.Errorf("%w: no instance registered", ErrSoakFailed)
+	}
+	leftAlone := make(map[string]bool)
+	for _, node := range report.Skipped {
+		leftAlone[node] = true
+	}
+	for _, node := range report.FailedNodes() {
+		leftAlone[node] = true
+	}
+	var problems []string
+	for _, s := range services {
+		if !leftAlone[s.Node] && !health.Passing(s) {
+			problems = append(problems, s.Node+" is "+health.Status(s))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrSoakFailed, strings.Join(problems, ", "))
+	}
+	return nil
+}
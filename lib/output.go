@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats of listing commands
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+	OutputCSV   = "csv"
+)
+
+// ParseOutputFormat checks an output format given on the command line
+func ParseOutputFormat(s string) (string, error) {
+	switch s {
+	case OutputTable, OutputJSON, OutputYAML, OutputCSV:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown output format %q, expected table, json, yaml or csv", s)
+}
+
+// WriteListing writes document as JSON or YAML, or header and rows as a table or CSV
+func WriteListing(w io.Writer, format string, document interface{}, header []string, rows [][]string) error {
+	switch format {
+	case OutputJSON:
+		out, err := json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(out))
+		return err
+	case OutputYAML:
+		return writeYAML(w, document)
+	case OutputCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(rows); err != nil {
+			return err
+		}
+		return cw.Error()
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	upper := make([]string, len(header))
+	for i, h := range header {
+		upper[i] = strings.ToUpper(h)
+	}
+	fmt.Fprintln(tw, strings.Join(upper, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// writeYAML writes document with the field names and omitempty rules of encoding/json: the JSON
+// document is decoded as a YAML node, which keeps the order of the fields, and encoded again in
+// block style.
+func writeYAML(w io.Writer, document interface{}) error {
+	out, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(out, &node); err != nil {
+		return err
+	}
+	if err := blockStyle(&node); err != nil {
+		return err
+	}
+	e := yaml.NewEncoder(w)
+	e.SetIndent(2)
+	if err := e.Encode(&node); err != nil {
+		return err
+	}
+	return e.Close()
+}
+
+// blockStyle drops the flow style and quotes of the JSON syntax. Strings are encoded again as Go
+// strings so that yaml quotes the ones a YAML 1.1 parser would not read back as strings, such as
+// on or n. Empty collections stay in flow style.
+func blockStyle(n *yaml.Node) error {
+	switch {
+	case n.Kind == yaml.ScalarNode && n.Tag == "!!str":
+		return n.Encode(n.Value)
+	case (n.Kind == yaml.MappingNode || n.Kind == yaml.SequenceNode) && len(n.Content) == 0:
+		n.Style = yaml.FlowStyle
+		return nil
+	}
+	n.Style = 0
+	for _, c := range n.Content {
+		if err := blockStyle(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+var serviceRecords = []ServiceRecord{
+	{Service: "wowza-edge", Node: "node1", Address: "10.0.0.1", Tags: []string{"image=registry:5000/wowza:1", "v1"}, CurrentConnections: 12, Reachable: true},
+	// an instance without tags, built like the list command does
+	NewServiceRecord(&api.CatalogService{ServiceName: "wowza-edge", Node: "node2", Address: "10.0.0.2"}, MetricsResult{Err: errors.New("i/o timeout")}),
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	if _, err := ParseOutputFormat("xml"); err == nil {
+		t.Error("xml output should be refused")
+	}
+	if f, err := ParseOutputFormat("yaml"); err != nil || f != OutputYAML {
+		t.Error("yaml output should be accepted", err)
+	}
+}
+
+func TestWriteServiceListJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteServiceList(&b, OutputJSON, serviceRecords); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded[0]["current_connections"] != float64(12) || decoded[0]["node"] != "node1" {
+		t.Error("Unexpected JSON", b.String())
+	}
+	if tags, ok := decoded[1]["tags"].([]interface{}); !ok || len(tags) != 0 {
+		t.Error("An instance without tags should have an empty list", b.String())
+	}
+}
+
+func TestWriteServiceListCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteServiceList(&b, OutputCSV, serviceRecords); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || lines[0] != "service,node,address,lan,wan,tags,current_connections,error" {
+		t.Error("Unexpected CSV", b.String())
+	}
+	if lines[1] != `wowza-edge,node1,10.0.0.1,,,"image=registry:5000/wowza:1,v1",12,` {
+		t.Error("Unexpected CSV row", lines[1])
+	}
+	if lines[2] != "wowza-edge,node2,10.0.0.2,,,,unreachable,i/o timeout" {
+		t.Error("Unreachable instance should not report 0 connections", lines[2])
+	}
+}
+
+func TestWriteServiceListYAML(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteServiceList(&b, OutputYAML, serviceRecords); err != nil {
+		t.Fatal(err)
+	}
+	expected := `- service: wowza-edge
+  node: node1
+  address: 10.0.0.1
+  lan: ""
+  wan: ""
+  tags:
+    - image=registry:5000/wowza:1
+    - v1
+  current_connections: 12
+  reachable: true
+- service: wowza-edge
+  node: node2
+  address: 10.0.0.2
+  lan: ""
+  wan: ""
+  tags: []
+  current_connections: 0
+  reachable: false
+  error: i/o timeout
+`
+	if b.String() != expected {
+		t.Error("Unexpected YAML", b.String())
+	}
+}
+
+func TestWriteMachineListYAML(t *testing.T) {
+	var b bytes.Buffer
+	records := []MachineRecord{{ID: "1a2b", PublicIP: "10.0.0.1", Metadata: map[string]string{"zone": "a", "role": "edge"}}}
+	if err := WriteMachineList(&b, OutputYAML, records); err != nil {
+		t.Fatal(err)
+	}
+	expected := `- id: 1a2b
+  public_ip: 10.0.0.1
+  metadata:
+    role: edge
+    zone: a
+`
+	if b.String() != expected {
+		t.Error("Unexpected YAML", b.String())
+	}
+}
+
+func TestWriteListingYAMLQuotesAmbiguousScalars(t *testing.T) {
+	var b bytes.Buffer
+	records := []MachineRecord{{ID: "on", PublicIP: "0x1F", Metadata: map[string]string{"y": "n", "off": "\x1f", "zone": "a"}}}
+	if err := WriteMachineList(&b, OutputYAML, records); err != nil {
+		t.Fatal(err)
+	}
+	expected := `- id: "on"
+  public_ip: "0x1F"
+  metadata:
+    "off": "\x1F"
+    "y": "n"
+    zone: a
+`
+	if b.String() != expected {
+		t.Error("Unexpected YAML", b.String())
+	}
+}
@@ -0,0 +1,289 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/schema"
+	"github.com/hashicorp/consul/api"
+)
+
+// PreflightError lists every pre-flight check which failed
+type PreflightError struct {
+	Failures []string
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("%d pre-flight checks failed:\n  - %s", len(e.Failures), strings.Join(e.Failures, "\n  - "))
+}
+
+type preflight struct {
+	failures []string
+}
+
+func (p *preflight) fail(format string, args ...interface{}) {
+	p.failures = append(p.failures, fmt.Sprintf(format, args...))
+}
+
+// Preflight checks that the rollout can start before any instance is touched:
+//   - the unit file parses and references the image
+//   - Consul answers and the token holds service:write on the service, and node:write on the nodes
+//     of the outdated instances when tags are written through the catalog. Nothing is written:
+//     Consul is asked through its ACL authorize endpoint, the check is logged as skipped when
+//     Consul lacks it.
+//   - fleet answers through the SSH tunnel
+//   - every outdated instance maps to one fleet machine and one unit
+//   - the Wowza server of every outdated instance with passing checks answers with the credentials
+//   - enough instances pass their checks for MinHealthy, and one of them can be drained under
+//     MaxUtilisation
+//
+// Every failure is reported in a PreflightError.
+func (u *Updater) Preflight(ctx context.Context) error {
+	p := &preflight{}
+	if err := CheckUnitImage(u.UnitPath, u.Image); err != nil {
+		p.fail("%v", err)
+	}
+
+	var machines []machine.MachineState
+	var units []*schema.Unit
+	fleetOK := false
+	if cAPI, err := GetClient(u.FleetSSHUser, u.FleetSSHServer); err != nil {
+		p.fail("fleet is unreachable through %s: %v", u.FleetSSHServer, err)
+	} else if machines, err = cAPI.Machines(); err != nil {
+		p.fail("unable to list fleet machines through %s: %v", u.FleetSSHServer, err)
+	} else if units, err = cAPI.Units(); err != nil {
+		p.fail("unable to list fleet units through %s: %v", u.FleetSSHServer, err)
+	} else {
+		fleetOK = true
+	}
+
+	q := (&api.QueryOptions{Datacenter: u.Dc}).WithContext(ctx)
+	services, _, err := u.Client.Catalog().Service(u.Service, "", q)
+	if err != nil {
+		p.fail("unable to read service %s from Consul: %v", u.Service, ExplainConsulError(err, "service:read on "+u.Service))
+	} else if len(services) == 0 {
+		p.fail("no instance of %s is registered in datacenter %s", u.Service, u.Dc)
+	} else {
+		if fleetOK && len(u.MachineSelector) > 0 {
+			services = SelectServices(services, machines, u.MachineResolver, u.MachineSelector)
+		}
+		if len(services) == 0 {
+			p.fail("no instance of %s runs on fleet machines matching -machine-selector", u.Service)
+		} else {
+			u.preflightServices(ctx, p, services, machines, units, fleetOK)
+		}
+	}
+	u.preflightToken(ctx, p, q, services)
+
+	if len(p.failures) > 0 {
+		return &PreflightError{Failures: p.failures}
+	}
+	log.Println("Pre-flight checks of", u.Service, "in datacenter", u.Dc, "passed")
+	return nil
+}
+
+// preflightToken checks that the Consul token can write the rollout state of the outdated instances
+// of services, it is skipped when ACLs are disabled
+func (u *Updater) preflightToken(ctx context.Context, p *preflight, q *api.QueryOptions, services []*api.CatalogService) {
+	token, _, err := u.Client.ACL().TokenReadSelf(q)
+	if err != nil {
+		if !strings.Contains(err.Error(), "ACL support disabled") {
+			p.fail("unable to read the Consul token: %v", ExplainConsulError(err, ""))
+		}
+		return
+	}
+	var policies []string
+	for _, policy := range token.Policies {
+		policies = append(policies, policy.Name)
+	}
+	log.Println("Consul token", token.AccessorID, "has policies", strings.Join(policies, ", "))
+
+	permissions := []ACLPermission{{Resource: "service", Segment: u.Service, Access: "write"}}
+	if u.TagUpdater == nil || u.TagUpdater.Strategy == TagUpdateCatalog {
+		state := RolloutState{Image: u.Image}
+		nodes := make(map[string]bool)
+		for _, s := range services {
+			if !state.Updated(s) && !nodes[s.Node] {
+				nodes[s.Node] = true
+				permissions = append(permissions, ACLPermission{Resource: "node", Segment: s.Node, Access: "write"})
+			}
+		}
+	}
+	if u.ConsulConfig == nil {
+		log.Println("The permissions of the Consul token can't be checked without the Consul configuration")
+		return
+	}
+	authorized, err := Authorize(ctx, u.ConsulConfig, u.Dc, permissions)
+	if errors.Is(err, ErrAuthorizeUnsupported) {
+		log.Println("The permissions of the Consul token can't be checked:", err)
+		return
+	} else if err != nil {
+		p.fail("unable to check the permissions of the Consul token: %v", ExplainConsulError(err, ""))
+		return
+	}
+	for _, permission := range authorized {
+		if !permission.Allow {
+			p.fail("the Consul token lacks %s:%s on %s", permission.Resource, permission.Access, permission.Segment)
+		}
+	}
+}
+
+// ACLPermission is a permission of the Consul token on a resource, Allow is set by Authorize
+type ACLPermission struct {
+	Resource string
+	Segment  string `json:",omitempty"`
+	Access   string
+	Allow    bool `json:",omitempty"`
+}
+
+// ErrAuthorizeUnsupported is returned by Authorize when Consul has no ACL authorize endpoint
+var ErrAuthorizeUnsupported = errors.New("Consul has no ACL authorize endpoint")
+
+// Authorize asks Consul which permissions its token holds. The api package has no client of the
+// authorize endpoint and api.Raw only sends PUT, the endpoint wants a POST.
+func Authorize(ctx context.Context, conf *api.Config, dc string, permissions []ACLPermission) ([]ACLPermission, error) {
+	if conf.HttpClient == nil {
+		// NewClient completes the configuration with its defaults
+		copied := *conf
+		if _, err := api.NewClient(&copied); err != nil {
+			return nil, err
+		}
+		conf = &copied
+	}
+	body, err := json.Marshal(permissions)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := conf.Scheme + "://" + conf.Address + "/v1/internal/acl/authorize"
+	if dc != "" {
+		endpoint += "?dc=" + url.QueryEscape(dc)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if conf.Token != "" {
+		req.Header.Set("X-Consul-Token", conf.Token)
+	}
+	resp, err := conf.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, fmt.Errorf("%w (%s)", ErrAuthorizeUnsupported, resp.Status)
+	default:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Unexpected response code: %d (%s)", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var authorized []ACLPermission
+	if err := json.NewDecoder(resp.Body).Decode(&authorized); err != nil {
+		return nil, err
+	}
+	return authorized, nil
+}
+
+func (u *Updater) preflightServices(ctx context.Context, p *preflight, services []*api.CatalogService, machines []machine.MachineState, units []*schema.Unit, fleetOK bool) {
+	state := RolloutState{Image: u.Image}
+	var outdated []*api.CatalogService
+	for _, s := range services {
+		if !state.Updated(s) {
+			outdated = append(outdated, s)
+		}
+	}
+
+	if fleetOK {
+		for _, s := range outdated {
+			m, err := u.MachineResolver.Resolve(machines, s)
+			if err != nil {
+				p.fail("instance %s on node %s: %v", s.ServiceID, s.Node, err)
+				continue
+			}
+			if u.GlobalUnit {
+				continue
+			}
+			found, err := FindServiceUnits(units, TemplateName(u.Service), m.ID, s, u.InstanceRule)
+			if err != nil {
+				p.fail("instance %s on node %s: %v", s.ServiceID, s.Node, err)
+			} else if len(found) != 1 {
+				p.fail("instance %s on node %s matches %d units of %s on machine %s, expected 1", s.ServiceID, s.Node, len(found), TemplateName(u.Service), m.ID)
+			}
+		}
+	}
+
+	health, err := GetServiceHealth(u.Client, u.Service, (&api.QueryOptions{Datacenter: u.Dc}).WithContext(ctx))
+	if err != nil {
+		p.fail("unable to read the health of %s: %v", u.Service, err)
+		return
+	}
+	if u.MinHealthy > 0 && health.PassingCount() <= u.MinHealthy {
+		p.fail("%d instances of %s pass their checks, -min-healthy %d leaves none to take down", health.PassingCount(), u.Service, u.MinHealthy)
+	}
+
+	// every instance is measured, the updated ones take connections too
+	var urls []string
+	for _, s := range services {
+		urls = append(urls, (&CatalogService{Dc: u.Dc, Cs: s}).GetURL())
+	}
+	metrics := make(map[string]Metrics)
+	for i, r := range CollectMetrics(ctx, urls, u.Transport, u.MetricsConcurrency, u.MetricsTimeout) {
+		s := services[i]
+		if r.Err == nil {
+			metrics[instanceKey(s.Node, s.ServiceID)] = r.Metrics
+		}
+		if state.Updated(s) {
+			continue
+		}
+		var status *StatusError
+		switch {
+		case r.Err == nil:
+		case errors.As(r.Err, &status) && status.StatusCode == http.StatusUnauthorized:
+			p.fail("Wowza on node %s refused the credentials: %v", s.Node, r.Err)
+		case health.Passing(s):
+			// instances with failing checks are expected not to answer
+			p.fail("Wowza on node %s does not answer at %s: %v", s.Node, urls[i], r.Err)
+		}
+	}
+	u.preflightCapacity(p, outdated, services, health, metrics)
+}
+
+// preflightCapacity checks that one outdated instance with passing checks can be drained under
+// MaxUtilisation, the threshold the rollout applies before draining an instance
+func (u *Updater) preflightCapacity(p *preflight, outdated []*api.CatalogService, services []*api.CatalogService, health ServiceHealth, metrics map[string]Metrics) {
+	if u.MaxUtilisation <= 0 {
+		return
+	}
+	lowest := -1.0
+	var unknown error
+	for _, s := range outdated {
+		if !health.Passing(s) {
+			// failing instances are drained without capacity check
+			return
+		}
+		utilisation, err := ProjectedUtilisation(s, services, health, metrics)
+		if err != nil {
+			unknown = err
+		} else if utilisation <= u.MaxUtilisation {
+			return
+		} else if lowest < 0 || utilisation < lowest {
+			lowest = utilisation
+		}
+	}
+	if lowest >= 0 {
+		p.fail("draining any outdated instance of %s would use at least %.1f%% of the connections of the other passing instances, at most %.1f%% allowed", u.Service, lowest, u.MaxUtilisation)
+	} else if unknown != nil {
+		p.fail("%v", unknown)
+	}
+}
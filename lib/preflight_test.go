@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestAuthorize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/internal/acl/authorize" || r.URL.Query().Get("dc") != "dc1" || r.Header.Get("X-Consul-Token") != "secret" {
+			t.Error("Unexpected request", r.Method, r.URL, r.Header)
+		}
+		var permissions []ACLPermission
+		if err := json.NewDecoder(r.Body).Decode(&permissions); err != nil {
+			t.Fatal(err)
+		}
+		for i := range permissions {
+			permissions[i].Allow = permissions[i].Resource == "service"
+		}
+		json.NewEncoder(w).Encode(permissions)
+	}))
+	defer server.Close()
+	conf := &api.Config{Address: strings.TrimPrefix(server.URL, "http://"), Scheme: "http", Token: "secret"}
+
+	authorized, err := Authorize(context.Background(), conf, "dc1", []ACLPermission{
+		{Resource: "service", Segment: "wowza-edge", Access: "write"},
+		{Resource: "node", Segment: "node1", Access: "write"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authorized) != 2 || !authorized[0].Allow || authorized[1].Allow {
+		t.Error("Only service:write should be allowed", authorized)
+	}
+}
+
+func TestAuthorizeUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	conf := &api.Config{Address: strings.TrimPrefix(server.URL, "http://"), Scheme: "http"}
+
+	if _, err := Authorize(context.Background(), conf, "", nil); !errors.Is(err, ErrAuthorizeUnsupported) {
+		t.Error("A Consul without the endpoint can't authorize", err)
+	}
+}
+
+func TestPreflightCapacity(t *testing.T) {
+	services := healthServices("node1", "node2", "node3")
+	health := NewServiceHealth([]*api.ServiceEntry{
+		healthEntry("node1", api.HealthPassing),
+		healthEntry("node2", api.HealthPassing),
+		healthEntry("node3", api.HealthPassing),
+	})
+	metrics := map[string]Metrics{
+		instanceKey("node1", "wowza-edge"): {CurrentConnections: 90, MaxConnections: 100},
+		instanceKey("node2", "wowza-edge"): {CurrentConnections: 60, MaxConnections: 100},
+		instanceKey("node3", "wowza-edge"): {CurrentConnections: 60, MaxConnections: 100},
+	}
+	u := &Updater{Service: "wowza-edge", MaxUtilisation: 80}
+
+	p := &preflight{}
+	u.preflightCapacity(p, services, services, health, metrics)
+	if len(p.failures) != 1 {
+		t.Error("Draining any node uses 105% of the others", p.failures)
+	}
+	u.MaxUtilisation = 105
+	p = &preflight{}
+	u.preflightCapacity(p, services, services, health, metrics)
+	if len(p.failures) != 0 {
+		t.Error("105% is allowed", p.failures)
+	}
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Errors of an instance which does not come back with the image once its unit is started again
+var (
+	ErrWrongImage    = errors.New("instance registered with another image")
+	ErrNotRegistered = errors.New("instance did not register")
+)
+
+// ImageOf returns the image an instance runs, from its image meta key or its image= tag
+func ImageOf(s *api.CatalogService) string {
+	if image, ok := s.ServiceMeta["image"]; ok {
+		return image
+	}
+	tag, _ := NewTagSet(s.ServiceTags).Get("image")
+	return tag.Value
+}
+
+// replacement returns the instance registered again on the node of s after s was read, with the
+// same service ID or port
+func replacement(services []*api.CatalogService, s *api.CatalogService) *api.CatalogService {
+	for _, current := range services {
+		if current.Node != s.Node || current.ModifyIndex <= s.ModifyIndex {
+			continue
+		}
+		if current.ServiceID == s.ServiceID || current.ServicePort == s.ServicePort {
+			return current
+		}
+	}
+	return nil
+}
+
+// waitRegistered waits until the instance started from unit registers again on its node, and
+// fails unless it registers with the image within RegisterTimeout
+func (u *Updater) waitRegistered(ctx context.Context, cs *CatalogService, unit string) error {
+	var found *api.CatalogService
+	q := &api.QueryOptions{Datacenter: u.Dc}
+	_, err := WaitService(ctx, u.Client, u.Service, q, time.Now().Add(u.registerTimeout()), func(services []*api.CatalogService) bool {
+		found = replacement(services, cs.Cs)
+		return found != nil
+	})
+	if err != nil && ctx.Err() != nil {
+		// the rollout is interrupted, the instance is checked when it is resumed
+		return nil
+	}
+	switch {
+	case errors.Is(err, ErrWaitTimeout):
+		err = fmt.Errorf("%w: no instance of %s registered on node %s within %s after starting unit %s",
+			ErrNotRegistered, u.Service, cs.Cs.Node, u.registerTimeout(), unit)
+	case err != nil:
+		return err
+	case !u.state.Updated(found):
+		err = fmt.Errorf("%w: %s on node %s registered with image %q instead of %s after starting unit %s, check the image of %s",
+			ErrWrongImage, found.ServiceID, found.Node, ImageOf(found), u.Image, unit, u.UnitPath)
+	default:
+		log.Println("Service", found.ServiceID, "registered on node", found.Node, "with image", u.Image)
+		cs.Cs = found
+		return nil
+	}
+	u.record(JournalEntry{Event: "register-failed", Node: cs.Cs.Node, Unit: unit, Message: err.Error()})
+	return err
+}
@@ -0,0 +1,34 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestImageOf(t *testing.T) {
+	s := &api.CatalogService{ServiceTags: []string{"v1", "image=registry:5000/wowza:1"}}
+	if ImageOf(s) != "registry:5000/wowza:1" {
+		t.Error("Unexpected image from tags", ImageOf(s))
+	}
+	s.ServiceMeta = map[string]string{"image": "wowza:2"}
+	if ImageOf(s) != "wowza:2" {
+		t.Error("Meta should be preferred to tags", ImageOf(s))
+	}
+}
+
+func TestReplacement(t *testing.T) {
+	old := &api.CatalogService{Node: "node1", ServiceID: "node1:wowza:1935", ServicePort: 1935, ModifyIndex: 10}
+	services := []*api.CatalogService{
+		{Node: "node1", ServiceID: "node1:wowza:1936", ServicePort: 1936, ModifyIndex: 12},
+		{Node: "node2", ServiceID: "node1:wowza:1935", ServicePort: 1935, ModifyIndex: 12},
+		{Node: "node1", ServiceID: "node1:wowza:1935", ServicePort: 1935, ModifyIndex: 10},
+	}
+	if s := replacement(services, old); s != nil {
+		t.Error("The instance did not register again", s)
+	}
+	services = append(services, &api.CatalogService{Node: "node1", ServiceID: "node1:wowza-new:1935", ServicePort: 1935, ModifyIndex: 15})
+	if s := replacement(services, old); s != services[3] {
+		t.Error("Unexpected replacement", s)
+	}
+}
@@ -0,0 +1,212 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Where the rollout state of instances is stored
+const (
+	// StateAuto stores the state in service meta when Consul supports it, in tags otherwise
+	StateAuto = "auto"
+	// StateInMeta stores the state in service meta
+	StateInMeta = "meta"
+	// StateInTags stores the state in update= and commit= service tags
+	StateInTags = "tags"
+)
+
+// Service meta keys of the rollout state
+const (
+	MetaRolloutImage     = "rollout-image"
+	MetaRolloutID        = "rollout-id"
+	MetaRolloutPhase     = "rollout-phase"
+	MetaRolloutStartedAt = "rollout-started-at"
+	MetaRolloutCommit    = "rollout-commit"
+)
+
+// Phases of an instance in a rollout
+const (
+	PhaseDraining   = "draining"
+	PhaseRecreating = "recreating"
+)
+
+// ParseRolloutStateMode checks a rollout state mode given on the command line
+func ParseRolloutStateMode(s string) (string, error) {
+	switch s {
+	case StateAuto, StateInMeta, StateInTags:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown rollout state mode %q, expected auto, meta or tags", s)
+}
+
+// ResolveRolloutStateMode returns where the rollout state is stored for a mode and a tag update
+// strategy. Meta written in the catalog is reverted by the anti-entropy of the agent, which tag
+// override does not cover, so meta is only used with the agent strategy: auto falls back to tags
+// and meta is refused otherwise. metaSupported is only called when auto may use meta.
+func ResolveRolloutStateMode(mode, tagUpdate string, metaSupported func() (bool, error)) (string, error) {
+	switch mode {
+	case StateInTags:
+		return mode, nil
+	case StateInMeta:
+		if tagUpdate != TagUpdateAgent {
+			return "", fmt.Errorf("-rollout-state meta needs -tag-update agent, the Consul agent reverts meta written in the catalog")
+		}
+		return mode, nil
+	}
+	if tagUpdate != TagUpdateAgent {
+		return StateInTags, nil
+	}
+	supported, err := metaSupported()
+	if err != nil {
+		return "", err
+	}
+	if supported {
+		return StateInMeta, nil
+	}
+	return StateInTags, nil
+}
+
+// versionAtLeast reports whether a x.y.z version is at least min
+func versionAtLeast(version string, min [3]int) bool {
+	parts := strings.SplitN(strings.SplitN(version, "-", 2)[0], ".", 3)
+	for i := 0; i < 3; i++ {
+		n := 0
+		if i < len(parts) {
+			n, _ = strconv.Atoi(parts[i])
+		}
+		if n != min[i] {
+			return n > min[i]
+		}
+	}
+	return true
+}
+
+// MetaSupported reports whether the Consul agent supports service meta, added in Consul 1.0.7
+func MetaSupported(c *api.Client) (bool, error) {
+	self, err := c.Agent().Self()
+	if err != nil {
+		return false, ExplainConsulError(err, "agent:read")
+	}
+	version, _ := self["Config"]["Version"].(string)
+	return versionAtLeast(version, [3]int{1, 0, 7}), nil
+}
+
+// NewRolloutID returns a random identifier of a rollout
+func NewRolloutID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// RolloutState reads and writes the rollout state of instances. Instances being updated are
+// recognised from service meta and from update= tags whatever Mode is, so that a rollout started
+// in one mode can be resumed in the other.
+type RolloutState struct {
+	Mode      string
+	ID        string
+	Image     string
+	Commit    string
+	StartedAt time.Time
+}
+
+// ImageLabel is the label set by registrator on instances running the image
+func (r RolloutState) ImageLabel() Tag {
+	return Tag{Key: "image", Value: r.Image}
+}
+
+func (r RolloutState) updateTag() Tag {
+	return Tag{Key: "update", Value: r.Image}
+}
+
+func (r RolloutState) commitTag() Tag {
+	return Tag{Key: "commit", Value: ShortCommit(r.Commit)}
+}
+
+// Updated reports whether an instance runs the image
+func (r RolloutState) Updated(s *api.CatalogService) bool {
+	cs := CatalogService{Cs: s}
+	return cs.HasLabel(r.ImageLabel())
+}
+
+// Draining reports whether an instance is being updated to the image
+func (r RolloutState) Draining(s *api.CatalogService) bool {
+	cs := CatalogService{Cs: s}
+	return cs.HasLabel(Tag{Key: MetaRolloutImage, Value: r.Image}) || cs.HasTag(r.updateTag())
+}
+
+// Phase returns the phase of an instance being updated, draining when it is only known from tags
+func (r RolloutState) Phase(s *api.CatalogService) string {
+	if !r.Draining(s) {
+		return ""
+	}
+	if phase := s.ServiceMeta[MetaRolloutPhase]; phase != "" {
+		return phase
+	}
+	return PhaseDraining
+}
+
+// Mark records that an instance entered a phase of the rollout. In tags mode, only the draining
+// phase is recorded, as update= and commit= tags.
+func (r RolloutState) Mark(ctx context.Context, c *api.Client, cs *CatalogService, phase string) error {
+	if r.Mode == StateInTags {
+		if phase != PhaseDraining {
+			return nil
+		}
+		// an update tag left by a previous rollout to another image is replaced
+		if err := cs.ServiceSetTagWithContext(ctx, c, cs.Cs, r.updateTag()); err != nil {
+			return err
+		}
+		if r.Commit != "" {
+			return cs.ServiceSetTagWithContext(ctx, c, cs.Cs, r.commitTag())
+		}
+		return nil
+	}
+	return cs.tagUpdater().Update(ctx, c, cs, func(tags *TagSet, meta map[string]string) (bool, error) {
+		state := map[string]string{
+			MetaRolloutImage:     r.Image,
+			MetaRolloutID:        r.ID,
+			MetaRolloutPhase:     phase,
+			MetaRolloutStartedAt: r.StartedAt.UTC().Format(time.RFC3339),
+		}
+		if r.Commit != "" {
+			state[MetaRolloutCommit] = ShortCommit(r.Commit)
+		}
+		changed := false
+		for k, v := range state {
+			if meta[k] != v {
+				meta[k] = v
+				changed = true
+			}
+		}
+		return changed, nil
+	})
+}
+
+// Clear removes the rollout state of an instance, from its meta and its update= and commit= tags
+func (r RolloutState) Clear(ctx context.Context, c *api.Client, cs *CatalogService) error {
+	return cs.tagUpdater().Update(ctx, c, cs, func(tags *TagSet, meta map[string]string) (bool, error) {
+		changed := false
+		for _, k := range []string{MetaRolloutImage, MetaRolloutID, MetaRolloutPhase, MetaRolloutStartedAt, MetaRolloutCommit} {
+			if _, ok := meta[k]; ok {
+				delete(meta, k)
+				changed = true
+			}
+		}
+		if tags.Remove(r.updateTag()) {
+			changed = true
+		}
+		if r.Commit != "" && tags.Remove(r.commitTag()) {
+			changed = true
+		}
+		return changed, nil
+	})
+}
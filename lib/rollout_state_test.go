@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	min := [3]int{1, 0, 7}
+	for v, expected := range map[string]bool{
+		"1.0.7":      true,
+		"1.4.0":      true,
+		"1.10.1-ent": true,
+		"1.0.6":      false,
+		"0.9.3":      false,
+		"":           false,
+	} {
+		if versionAtLeast(v, min) != expected {
+			t.Error("Unexpected comparison of", v, "with 1.0.7")
+		}
+	}
+}
+
+func TestResolveRolloutStateMode(t *testing.T) {
+	supported := func() (bool, error) { return true, nil }
+	unsupported := func() (bool, error) { return false, nil }
+	for _, c := range []struct {
+		mode, tagUpdate string
+		metaSupported   func() (bool, error)
+		expected        string
+	}{
+		{StateAuto, TagUpdateCatalog, supported, StateInTags},
+		{StateAuto, TagUpdateAgent, supported, StateInMeta},
+		{StateAuto, TagUpdateAgent, unsupported, StateInTags},
+		{"", TagUpdateCatalog, supported, StateInTags},
+		{StateInTags, TagUpdateCatalog, supported, StateInTags},
+		{StateInTags, TagUpdateAgent, supported, StateInTags},
+		{StateInMeta, TagUpdateAgent, unsupported, StateInMeta},
+		{StateInMeta, TagUpdateCatalog, supported, ""},
+	} {
+		mode, err := ResolveRolloutStateMode(c.mode, c.tagUpdate, c.metaSupported)
+		if mode != c.expected || (err != nil) != (c.expected == "") {
+			t.Error("Unexpected state mode for", c.mode, "with", c.tagUpdate, mode, err)
+		}
+	}
+}
+
+func TestRolloutStateFromMetaAndTags(t *testing.T) {
+	state := RolloutState{Image: "registry:5000/wowza:2"}
+	inMeta := &api.CatalogService{
+		ServiceTags: []string{"image=registry:5000/wowza:1"},
+		ServiceMeta: map[string]string{MetaRolloutImage: "registry:5000/wowza:2", MetaRolloutPhase: PhaseRecreating},
+	}
+	inTags := &api.CatalogService{ServiceTags: []string{"image=registry:5000/wowza:1", "update=registry:5000/wowza:2"}}
+	updated := &api.CatalogService{ServiceMeta: map[string]string{"image": "registry:5000/wowza:2"}}
+	other := &api.CatalogService{ServiceTags: []string{"update=registry:5000/wowza:3"}}
+
+	if !state.Draining(inMeta) || state.Phase(inMeta) != PhaseRecreating || state.Updated(inMeta) {
+		t.Error("Instance is recreated according to its meta")
+	}
+	if !state.Draining(inTags) || state.Phase(inTags) != PhaseDraining {
+		t.Error("Instance is drained according to its tags")
+	}
+	if !state.Updated(updated) || state.Draining(updated) || state.Phase(updated) != "" {
+		t.Error("Instance runs the image according to its meta")
+	}
+	if state.Draining(other) {
+		t.Error("Instance is updated to another image")
+	}
+	if s, ok := SearchService([]*api.CatalogService{updated, inTags, inMeta}, state.Draining); !ok || s.ServiceTags[1] != "update=registry:5000/wowza:2" {
+		t.Error("First instance being updated should be found", s, ok)
+	}
+}
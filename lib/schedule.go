@@ -0,0 +1,175 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window is a weekly maintenance window, from Start to End (HH:MM) on each of Days (mon, tue, ...).
+// A window ending before it starts ends the next day.
+type Window struct {
+	Days  []string `json:"days"`
+	Start string   `json:"start"`
+	End   string   `json:"end"`
+
+	days       map[time.Weekday]bool
+	start, end int
+}
+
+// Blackout is a period of days (YYYY-MM-DD) without rollout, To is From when empty
+type Blackout struct {
+	From   string `json:"from"`
+	To     string `json:"to,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	from, to time.Time
+}
+
+// Schedule tells when instances may be updated: within one of Windows, when there are any, and
+// outside Blackouts. Times are in Timezone, UTC when empty.
+type Schedule struct {
+	Timezone  string     `json:"timezone,omitempty"`
+	Windows   []Window   `json:"windows,omitempty"`
+	Blackouts []Blackout `json:"blackouts,omitempty"`
+
+	location *time.Location
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (s *Schedule) parse() error {
+	s.location = time.UTC
+	if s.Timezone != "" {
+		location, err := time.LoadLocation(s.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %v", s.Timezone, err)
+		}
+		s.location = location
+	}
+	for i := range s.Windows {
+		w := &s.Windows[i]
+		if len(w.Days) == 0 {
+			return fmt.Errorf("window %d has no days", i+1)
+		}
+		w.days = make(map[time.Weekday]bool)
+		for _, d := range w.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return fmt.Errorf("window %d: unknown day %q, expected mon, tue, wed, thu, fri, sat or sun", i+1, d)
+			}
+			w.days[day] = true
+		}
+		var err error
+		if w.start, err = parseClock(w.Start); err != nil {
+			return fmt.Errorf("window %d: %v", i+1, err)
+		}
+		if w.end, err = parseClock(w.End); err != nil {
+			return fmt.Errorf("window %d: %v", i+1, err)
+		}
+		if w.start == w.end {
+			return fmt.Errorf("window %d starts and ends at %s", i+1, w.Start)
+		}
+	}
+	for i := range s.Blackouts {
+		b := &s.Blackouts[i]
+		var err error
+		if b.from, err = time.ParseInLocation("2006-01-02", b.From, s.location); err != nil {
+			return fmt.Errorf("blackout %d: invalid date %q, expected YYYY-MM-DD", i+1, b.From)
+		}
+		b.to = b.from
+		if b.To != "" {
+			if b.to, err = time.ParseInLocation("2006-01-02", b.To, s.location); err != nil {
+				return fmt.Errorf("blackout %d: invalid date %q, expected YYYY-MM-DD", i+1, b.To)
+			}
+		}
+		if b.to.Before(b.from) {
+			return fmt.Errorf("blackout %d ends before it starts", i+1)
+		}
+		// the blackout lasts until the end of its last day
+		b.to = b.to.AddDate(0, 0, 1)
+	}
+	return nil
+}
+
+// clockAt returns the time of day at minutes since midnight of the day of t
+func clockAt(t time.Time, minutes int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), minutes/60, minutes%60, 0, 0, t.Location())
+}
+
+// open reports whether the window is open at t
+func (w Window) open(t time.Time) bool {
+	minutes := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.days[t.Weekday()] && minutes >= w.start && minutes < w.end
+	}
+	// the window ends the day after it starts
+	yesterday := t.AddDate(0, 0, -1).Weekday()
+	return (w.days[t.Weekday()] && minutes >= w.start) || (w.days[yesterday] && minutes < w.end)
+}
+
+// Open reports whether instances may be updated at t, with the reason when they may not
+func (s *Schedule) Open(t time.Time) (bool, string) {
+	t = t.In(s.location)
+	for _, b := range s.Blackouts {
+		if !t.Before(b.from) && t.Before(b.to) {
+			if b.Reason != "" {
+				return false, "blackout: " + b.Reason
+			}
+			return false, "blackout"
+		}
+	}
+	if len(s.Windows) == 0 {
+		return true, ""
+	}
+	for _, w := range s.Windows {
+		if w.open(t) {
+			return true, ""
+		}
+	}
+	return false, "outside maintenance windows"
+}
+
+// NextOpen returns the first time from t when instances may be updated, within a year
+func (s *Schedule) NextOpen(t time.Time) (time.Time, error) {
+	if open, _ := s.Open(t); open {
+		return t, nil
+	}
+	t = t.In(s.location)
+	var next time.Time
+	consider := func(c time.Time) {
+		if c.After(t) && (next.IsZero() || c.Before(next)) {
+			if open, _ := s.Open(c); open {
+				next = c
+			}
+		}
+	}
+	for _, b := range s.Blackouts {
+		consider(b.to)
+	}
+	for day := 0; day <= 366; day++ {
+		d := t.AddDate(0, 0, day)
+		for _, w := range s.Windows {
+			if w.days[d.Weekday()] {
+				consider(clockAt(d, w.start))
+			}
+		}
+	}
+	if next.IsZero() {
+		return next, errors.New("the schedule does not open within a year")
+	}
+	return next, nil
+}
@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func mustSchedule(t *testing.T, s Schedule) *Schedule {
+	if err := s.parse(); err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+func TestScheduleParseInvalid(t *testing.T) {
+	for _, s := range []Schedule{
+		{Timezone: "Mars/Olympus"},
+		{Windows: []Window{{Start: "02:00", End: "06:00"}}},
+		{Windows: []Window{{Days: []string{"monday"}, Start: "02:00", End: "06:00"}}},
+		{Windows: []Window{{Days: []string{"mon"}, Start: "2h", End: "06:00"}}},
+		{Windows: []Window{{Days: []string{"mon"}, Start: "02:00", End: "02:00"}}},
+		{Blackouts: []Blackout{{From: "24/12/2026"}}},
+		{Blackouts: []Blackout{{From: "2026-12-26", To: "2026-12-24"}}},
+	} {
+		if err := s.parse(); err == nil {
+			t.Error("Schedule should be refused:", s)
+		}
+	}
+}
+
+func TestScheduleOpen(t *testing.T) {
+	s := mustSchedule(t, Schedule{
+		Timezone: "Europe/Paris",
+		Windows: []Window{
+			{Days: []string{"mon", "tue", "wed", "thu"}, Start: "02:00", End: "06:00"},
+			{Days: []string{"sat"}, Start: "23:00", End: "03:00"},
+		},
+		Blackouts: []Blackout{{From: "2026-10-20", Reason: "match day"}},
+	})
+	paris, _ := time.LoadLocation("Europe/Paris")
+	for _, c := range []struct {
+		at   time.Time
+		open bool
+	}{
+		{time.Date(2026, 10, 19, 3, 0, 0, 0, paris), true},    // monday
+		{time.Date(2026, 10, 19, 6, 0, 0, 0, paris), false},   // monday, the window is over
+		{time.Date(2026, 10, 19, 1, 0, 0, 0, time.UTC), true}, // 03:00 in Paris
+		{time.Date(2026, 10, 20, 3, 0, 0, 0, paris), false},   // tuesday blackout
+		{time.Date(2026, 10, 24, 23, 30, 0, 0, paris), true},  // saturday night
+		{time.Date(2026, 10, 25, 2, 30, 0, 0, paris), true},   // sunday morning, in saturday's window
+		{time.Date(2026, 10, 25, 23, 30, 0, 0, paris), false}, // sunday night
+		{time.Date(2026, 10, 23, 3, 0, 0, 0, paris), false},   // friday
+	} {
+		if open, reason := s.Open(c.at); open != c.open {
+			t.Error("Unexpected schedule at", c.at, open, reason)
+		}
+	}
+	if _, reason := s.Open(time.Date(2026, 10, 20, 3, 0, 0, 0, paris)); reason != "blackout: match day" {
+		t.Error("Unexpected reason", reason)
+	}
+}
+
+func TestScheduleNextOpen(t *testing.T) {
+	s := mustSchedule(t, Schedule{
+		Windows:   []Window{{Days: []string{"mon", "tue", "wed"}, Start: "02:00", End: "06:00"}},
+		Blackouts: []Blackout{{From: "2026-10-20", To: "2026-10-21"}},
+	})
+	// monday 2026-10-19 after the window, tuesday and wednesday are blacked out
+	next, err := s.NextOpen(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+	if err != nil || !next.Equal(time.Date(2026, 10, 26, 2, 0, 0, 0, time.UTC)) {
+		t.Error("Unexpected next opening", next, err)
+	}
+	at := time.Date(2026, 10, 19, 3, 0, 0, 0, time.UTC)
+	if next, err := s.NextOpen(at); err != nil || !next.Equal(at) {
+		t.Error("The schedule is open", next, err)
+	}
+	blackout := mustSchedule(t, Schedule{Blackouts: []Blackout{{From: "2026-10-20"}}})
+	next, err = blackout.NextOpen(time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC))
+	if err != nil || !next.Equal(time.Date(2026, 10, 21, 0, 0, 0, 0, time.UTC)) {
+		t.Error("The schedule opens after the blackout", next, err)
+	}
+}
@@ -0,0 +1,181 @@
+package lib
+
+import (
+	"context"
+	"time"
+	"wowza-rolling-update/digest"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/coreos/fleet/schema"
+	"github.com/hashicorp/consul/api"
+)
+
+// UnitStatus is the fleet and systemd state of a unit backing a service instance
+type UnitStatus struct {
+	Name         string `json:"name"`
+	CurrentState string `json:"current_state"`
+	ActiveState  string `json:"active_state"`
+	SubState     string `json:"sub_state"`
+}
+
+// InstanceStatus is the rollout state of a service instance
+type InstanceStatus struct {
+	Node     string
+	Address  string
+	Tags     []string
+	Updated  bool
+	Draining bool
+	// Phase and Rollout are the phase and rollout ID of an instance being updated, when known
+	Phase              string
+	Rollout            string
+	Health             string
+	Measured           bool // the Wowza connections are only fetched for instances being updated
+	CurrentConnections int32
+	MetricsError       error
+	Units              []UnitStatus
+
+	cs *api.CatalogService
+}
+
+// RolloutStatus is a read-only view of a rollout built from Consul and fleet
+type RolloutStatus struct {
+	Service   string
+	Dc        string
+	Image     string
+	Instances []InstanceStatus
+	// ETA is the estimated remaining time, zero when unknown
+	ETA time.Duration
+}
+
+// Updated returns the number of instances running the target image
+func (s RolloutStatus) Updated() int {
+	n := 0
+	for _, i := range s.Instances {
+		if i.Updated {
+			n++
+		}
+	}
+	return n
+}
+
+// Progress returns the percentage of instances running the target image
+func (s RolloutStatus) Progress() float64 {
+	if len(s.Instances) == 0 {
+		return 100
+	}
+	return float64(s.Updated()) * 100 / float64(len(s.Instances))
+}
+
+// GetRolloutStatus reads the instances of a service from Consul with their health, and the Wowza
+// connections of the instances being updated with at most concurrency requests bounded by timeout
+func GetRolloutStatus(ctx context.Context, client *api.Client, transport *digest.Transport, service string, dc string, image string, concurrency int, timeout time.Duration) (RolloutStatus, error) {
+	status := RolloutStatus{Service: service, Dc: dc, Image: image}
+	catalogServices, _, err := client.Catalog().Service(service, "", &api.QueryOptions{Datacenter: dc})
+	if err != nil {
+		return status, ExplainConsulError(err, "service:read on "+service)
+	}
+	health, err := GetServiceHealth(client, service, &api.QueryOptions{Datacenter: dc})
+	if err != nil {
+		return status, err
+	}
+	state := RolloutState{Image: image}
+	var draining []int
+	var urls []string
+	for _, s := range catalogServices {
+		cs := CatalogService{Dc: dc, Cs: s}
+		instance := InstanceStatus{
+			Node:     s.Node,
+			Address:  s.Address,
+			Tags:     s.ServiceTags,
+			Updated:  state.Updated(s),
+			Draining: state.Draining(s),
+			Phase:    state.Phase(s),
+			Rollout:  s.ServiceMeta[MetaRolloutID],
+			Health:   health.Status(s),
+			cs:       s,
+		}
+		if instance.Draining {
+			draining = append(draining, len(status.Instances))
+			urls = append(urls, cs.GetURL())
+		}
+		status.Instances = append(status.Instances, instance)
+	}
+	for i, r := range CollectMetrics(ctx, urls, transport, concurrency, timeout) {
+		instance := &status.Instances[draining[i]]
+		instance.Measured = true
+		instance.CurrentConnections = r.Metrics.CurrentConnections
+		instance.MetricsError = r.Err
+	}
+	return status, nil
+}
+
+// AddUnits attaches to every instance the state of the fleet units backing it
+func (s *RolloutStatus) AddUnits(units []*schema.Unit, states []*schema.UnitState, machines []machine.MachineState, resolver MachineResolver, rule InstanceRule) {
+	for i := range s.Instances {
+		cs := s.Instances[i].cs
+		m, err := resolver.Resolve(machines, cs)
+		if err != nil {
+			continue
+		}
+		found, _ := FindServiceUnits(units, TemplateName(s.Service), m.ID, cs, rule)
+		for _, u := range found {
+			us := UnitStatus{Name: u.Name, CurrentState: u.CurrentState}
+			for _, st := range states {
+				if st.Name == u.Name && st.MachineID == m.ID {
+					us.ActiveState = st.SystemdActiveState
+					us.SubState = st.SystemdSubState
+				}
+			}
+			s.Instances[i].Units = append(s.Instances[i].Units, us)
+		}
+	}
+}
+
+// EstimateETA estimates the remaining time from the pace of the rollout recorded in the journal.
+// Only the entries since the last rollout-start count, and the time spent paused by the schedule
+// or waiting for capacity is left out.
+func (s *RolloutStatus) EstimateETA(entries []JournalEntry) {
+	var run []JournalEntry
+	for _, e := range entries {
+		if e.Service != s.Service || e.Dc != s.Dc || e.Image != s.Image {
+			continue
+		}
+		if e.Event == "rollout-start" {
+			run = nil
+		}
+		run = append(run, e)
+	}
+	if len(run) == 0 || run[0].Event != "rollout-start" {
+		return
+	}
+	started := 0
+	var worked, elapsed time.Duration
+	from := run[0].Time
+	waiting := false
+	for _, e := range run[1:] {
+		switch e.Event {
+		case "paused", "capacity-wait":
+			if !waiting {
+				worked += e.Time.Sub(from)
+				waiting = true
+			}
+		case "resumed", "tagged":
+			if waiting {
+				from = e.Time
+				waiting = false
+			}
+		case "started":
+			if waiting {
+				from = e.Time
+				waiting = false
+			}
+			started++
+			elapsed = worked + e.Time.Sub(from)
+		}
+	}
+	if started == 0 {
+		return
+	}
+	perInstance := elapsed / time.Duration(started)
+	s.ETA = perInstance * time.Duration(len(s.Instances)-s.Updated())
+}
@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRolloutStatusProgressAndETA(t *testing.T) {
+	status := RolloutStatus{
+		Service: "wowza-edge",
+		Dc:      "dc1",
+		Image:   "wowza:2",
+		Instances: []InstanceStatus{
+			{Node: "node1", Updated: true},
+			{Node: "node2", Updated: true},
+			{Node: "node3", Draining: true},
+			{Node: "node4"},
+		},
+	}
+	if status.Updated() != 2 || status.Progress() != 50 {
+		t.Error("Half of instances are updated, got", status.Updated(), status.Progress())
+	}
+
+	start := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	status.EstimateETA([]JournalEntry{
+		{Time: start.Add(-time.Hour), Event: "started", Service: "wowza-edge", Dc: "dc1", Image: "wowza:1"},
+		{Time: start, Event: "rollout-start", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start.Add(4 * time.Minute), Event: "started", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start.Add(10 * time.Minute), Event: "started", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+	})
+	if status.ETA != 10*time.Minute {
+		t.Error("Two instances left at 5 minutes per instance, ETA is", status.ETA)
+	}
+
+	status.EstimateETA([]JournalEntry{
+		{Time: start.Add(-3 * time.Hour), Event: "rollout-start", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start.Add(-2 * time.Hour), Event: "started", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start, Event: "rollout-start", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start.Add(2 * time.Minute), Event: "paused", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start.Add(time.Hour), Event: "resumed", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start.Add(time.Hour + 2*time.Minute), Event: "started", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start.Add(time.Hour + 3*time.Minute), Event: "capacity-wait", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start.Add(time.Hour + 30*time.Minute), Event: "tagged", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+		{Time: start.Add(time.Hour + 35*time.Minute), Event: "started", Service: "wowza-edge", Dc: "dc1", Image: "wowza:2"},
+	})
+	if status.ETA != 10*time.Minute {
+		t.Error("The previous run and the waits should not count, ETA is", status.ETA)
+	}
+}
+
+func TestRolloutStatusRecordReachable(t *testing.T) {
+	status := RolloutStatus{
+		Instances: []InstanceStatus{
+			{Node: "node1", Draining: true, Measured: true, CurrentConnections: 3},
+			{Node: "node2", Draining: true, Measured: true, MetricsError: errors.New("i/o timeout")},
+			{Node: "node3"},
+		},
+	}
+	instances := status.Record().Instances
+	if instances[0].Reachable == nil || !*instances[0].Reachable {
+		t.Error("node1 was measured", instances[0].Reachable)
+	}
+	if instances[1].Reachable == nil || *instances[1].Reachable {
+		t.Error("node2 is unreachable", instances[1].Reachable)
+	}
+	if instances[2].Reachable != nil {
+		t.Error("node3 was not measured", *instances[2].Reachable)
+	}
+	var b bytes.Buffer
+	if err := WriteRolloutStatus(&b, OutputJSON, status); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(b.String(), `"reachable"`) != 2 {
+		t.Error("reachable should be omitted when not measured", b.String())
+	}
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Strategies ordering the instances to update
+const (
+	StrategyCatalog           = "catalog"
+	StrategyFewestConnections = "fewest-connections"
+	StrategyUnhealthyFirst    = "unhealthy-first"
+	StrategyNodeName          = "node"
+	StrategyZoneRoundRobin    = "zone-round-robin"
+	StrategyOrderFile         = "order-file"
+)
+
+// SelectionStrategy orders the instances to update, the first one is updated next
+type SelectionStrategy struct {
+	Kind string
+	// Order lists node names in update order, for the order-file strategy
+	Order []string
+}
+
+// SelectionInfo is what strategies know about instances, indexed by node and service ID
+type SelectionInfo struct {
+	Health ServiceHealth
+	// Connections holds the Wowza connections of reachable instances
+	Connections map[string]int32
+	// Zones holds the zone of instances whose fleet machine is known, when a zone key is given
+	Zones map[string]string
+}
+
+// ParseSelectionStrategy builds a SelectionStrategy from catalog, fewest-connections, unhealthy-first,
+// node, zone-round-robin or order-file:<path>
+func ParseSelectionStrategy(s string) (SelectionStrategy, error) {
+	kind, path := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, path = s[:i], s[i+1:]
+	}
+	switch kind {
+	case StrategyCatalog, StrategyFewestConnections, StrategyUnhealthyFirst, StrategyNodeName, StrategyZoneRoundRobin:
+		if path == "" {
+			return SelectionStrategy{Kind: kind}, nil
+		}
+	case StrategyOrderFile:
+		if path != "" {
+			order, err := readOrderFile(path)
+			if err != nil {
+				return SelectionStrategy{}, err
+			}
+			return SelectionStrategy{Kind: kind, Order: order}, nil
+		}
+	}
+	return SelectionStrategy{}, fmt.Errorf("unknown selection strategy %q, expected catalog, fewest-connections, unhealthy-first, node, zone-round-robin or order-file:<path>", s)
+}
+
+// readOrderFile reads node names, one per line, blank lines and # comments are ignored
+func readOrderFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var order []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		order = append(order, line)
+	}
+	return order, scanner.Err()
+}
+
+// WithHealthPolicy returns the strategy to follow with a health policy. The strategy orders the
+// instances and the policy only tells whether failing ones are updated, except for the catalog
+// strategy which leaves the order to the policy: failing instances come first with HealthFailingFirst.
+func (st SelectionStrategy) WithHealthPolicy(policy string) SelectionStrategy {
+	if st.Kind == StrategyCatalog && policy == HealthFailingFirst {
+		return SelectionStrategy{Kind: StrategyUnhealthyFirst}
+	}
+	return st
+}
+
+// NeedsConnections reports whether the strategy orders instances by their Wowza connections
+func (st SelectionStrategy) NeedsConnections() bool {
+	return st.Kind == StrategyFewestConnections
+}
+
+// NeedsZones reports whether the strategy orders instances by their zone
+func (st SelectionStrategy) NeedsZones() bool {
+	return st.Kind == StrategyZoneRoundRobin
+}
+
+// Sort orders the instances without imageTag, instances already updated are left out. Instances
+// without known connections or missing from the order file come last, in catalog order. When zones
+// are known, every strategy but zone-round-robin finishes a zone before starting the next one, in
+// zone name order, and only orders the instances of a zone.
+func (st SelectionStrategy) Sort(services []*api.CatalogService, imageTag Tag, info SelectionInfo) []*api.CatalogService {
+	var outdated []*api.CatalogService
+	for _, s := range services {
+		cs := CatalogService{Cs: s}
+		if !cs.HasLabel(imageTag) {
+			outdated = append(outdated, s)
+		}
+	}
+	switch st.Kind {
+	case StrategyFewestConnections:
+		sort.SliceStable(outdated, func(i, j int) bool {
+			ci, iok := info.Connections[instanceKey(outdated[i].Node, outdated[i].ServiceID)]
+			cj, jok := info.Connections[instanceKey(outdated[j].Node, outdated[j].ServiceID)]
+			if iok != jok {
+				return iok
+			}
+			return ci < cj
+		})
+	case StrategyUnhealthyFirst:
+		sort.SliceStable(outdated, func(i, j int) bool {
+			return !info.Health.Passing(outdated[i]) && info.Health.Passing(outdated[j])
+		})
+	case StrategyNodeName:
+		sort.SliceStable(outdated, func(i, j int) bool {
+			return outdated[i].Node < outdated[j].Node
+		})
+	case StrategyZoneRoundRobin:
+		outdated = zoneRoundRobin(services, outdated, imageTag, info.Zones)
+	case StrategyOrderFile:
+		rank := make(map[string]int)
+		for i, node := range st.Order {
+			if _, ok := rank[node]; !ok {
+				rank[node] = i
+			}
+		}
+		sort.SliceStable(outdated, func(i, j int) bool {
+			ri, iok := rank[outdated[i].Node]
+			rj, jok := rank[outdated[j].Node]
+			if iok != jok {
+				return iok
+			}
+			return ri < rj
+		})
+	}
+	if info.Zones != nil && st.Kind != StrategyZoneRoundRobin {
+		sort.SliceStable(outdated, func(i, j int) bool {
+			return info.Zones[instanceKey(outdated[i].Node, outdated[i].ServiceID)] < info.Zones[instanceKey(outdated[j].Node, outdated[j].ServiceID)]
+		})
+	}
+	return outdated
+}
+
+// zoneRoundRobin alternates zones so that they stay balanced: the next instance is taken from the
+// zone with the fewest updated instances, zones are then taken by name
+func zoneRoundRobin(services []*api.CatalogService, outdated []*api.CatalogService, imageTag Tag, zones map[string]string) []*api.CatalogService {
+	updated := make(map[string]int)
+	for _, s := range services {
+		cs := CatalogService{Cs: s}
+		if cs.HasLabel(imageTag) {
+			updated[zones[instanceKey(s.Node, s.ServiceID)]]++
+		}
+	}
+	seen := make(map[string]int)
+	turn := make(map[*api.CatalogService]int)
+	for _, s := range outdated {
+		zone := zones[instanceKey(s.Node, s.ServiceID)]
+		turn[s] = updated[zone] + seen[zone]
+		seen[zone]++
+	}
+	sort.SliceStable(outdated, func(i, j int) bool {
+		zi := zones[instanceKey(outdated[i].Node, outdated[i].ServiceID)]
+		zj := zones[instanceKey(outdated[j].Node, outdated[j].ServiceID)]
+		if turn[outdated[i]] != turn[outdated[j]] {
+			return turn[outdated[i]] < turn[outdated[j]]
+		}
+		return zi < zj
+	})
+	return outdated
+}
@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func nodes(services []*api.CatalogService) []string {
+	var names []string
+	for _, s := range services {
+		names = append(names, s.Node)
+	}
+	return names
+}
+
+func sameNodes(services []*api.CatalogService, expected ...string) bool {
+	names := nodes(services)
+	if len(names) != len(expected) {
+		return false
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseSelectionStrategy(t *testing.T) {
+	for _, s := range []string{"", "fewest", "node:foo", "order-file"} {
+		if _, err := ParseSelectionStrategy(s); err == nil {
+			t.Error("Strategy should be refused:", s)
+		}
+	}
+	dir, err := ioutil.TempDir("", "strategy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "order")
+	if err := ioutil.WriteFile(path, []byte("# canary first\nnode3\n\nnode1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := ParseSelectionStrategy("order-file:" + path)
+	if err != nil || len(st.Order) != 2 || st.Order[0] != "node3" || st.Order[1] != "node1" {
+		t.Error("Unexpected order file strategy", st, err)
+	}
+}
+
+func TestSelectionStrategySort(t *testing.T) {
+	image := Tag{Key: "image", Value: "wowza:2"}
+	services := healthServices("node3", "node1", "node4", "node2")
+	info := SelectionInfo{
+		Health: NewServiceHealth([]*api.ServiceEntry{
+			healthEntry("node3", api.HealthPassing),
+			healthEntry("node1", api.HealthPassing),
+			healthEntry("node4", api.HealthCritical),
+			healthEntry("node2", api.HealthPassing),
+		}),
+		Connections: map[string]int32{
+			instanceKey("node3", "wowza-edge"): 40,
+			instanceKey("node1", "wowza-edge"): 0,
+			instanceKey("node2", "wowza-edge"): 12,
+		},
+	}
+
+	if s := (SelectionStrategy{Kind: StrategyCatalog}).Sort(services, image, info); !sameNodes(s, "node3", "node1", "node4", "node2") {
+		t.Error("Catalog order should be kept", nodes(s))
+	}
+	if s := (SelectionStrategy{Kind: StrategyFewestConnections}).Sort(services, image, info); !sameNodes(s, "node1", "node2", "node3", "node4") {
+		t.Error("Emptiest instances should come first, unreachable last", nodes(s))
+	}
+	if s := (SelectionStrategy{Kind: StrategyUnhealthyFirst}).Sort(services, image, info); !sameNodes(s, "node4", "node3", "node1", "node2") {
+		t.Error("Unhealthy instance should come first", nodes(s))
+	}
+	if s := (SelectionStrategy{Kind: StrategyNodeName}).Sort(services, image, info); !sameNodes(s, "node1", "node2", "node3", "node4") {
+		t.Error("Instances should be sorted by node", nodes(s))
+	}
+	if s := (SelectionStrategy{Kind: StrategyOrderFile, Order: []string{"node2", "node4"}}).Sort(services, image, info); !sameNodes(s, "node2", "node4", "node3", "node1") {
+		t.Error("Order file should come first", nodes(s))
+	}
+
+	services[1].ServiceTags = []string{"image=wowza:2"}
+	if s := (SelectionStrategy{Kind: StrategyNodeName}).Sort(services, image, info); !sameNodes(s, "node2", "node3", "node4") {
+		t.Error("Updated instances should be left out", nodes(s))
+	}
+}
+
+func TestSelectionStrategyZoneRoundRobin(t *testing.T) {
+	image := Tag{Key: "image", Value: "wowza:2"}
+	services := healthServices("a1", "a2", "a3", "b1", "b2", "c1")
+	info := SelectionInfo{Zones: map[string]string{}}
+	for _, s := range services {
+		info.Zones[instanceKey(s.Node, s.ServiceID)] = s.Node[:1]
+	}
+	st := SelectionStrategy{Kind: StrategyZoneRoundRobin}
+	if s := st.Sort(services, image, info); !sameNodes(s, "a1", "b1", "c1", "a2", "b2", "a3") {
+		t.Error("Zones should alternate", nodes(s))
+	}
+	services[0].ServiceTags = []string{"image=wowza:2"}
+	if s := st.Sort(services, image, info); s[0].Node != "b1" {
+		t.Error("Zone b has no updated instance and comes next", nodes(s))
+	}
+}
+
+func TestSelectionStrategyWithDefaultHealthPolicy(t *testing.T) {
+	image := Tag{Key: "image", Value: "wowza:2"}
+	services := healthServices("node3", "node1", "node4", "node2")
+	info := SelectionInfo{
+		Health: NewServiceHealth([]*api.ServiceEntry{
+			healthEntry("node3", api.HealthPassing),
+			healthEntry("node1", api.HealthPassing),
+			healthEntry("node4", api.HealthCritical),
+			healthEntry("node2", api.HealthPassing),
+		}),
+		Connections: map[string]int32{
+			instanceKey("node3", "wowza-edge"): 40,
+			instanceKey("node1", "wowza-edge"): 0,
+			instanceKey("node2", "wowza-edge"): 12,
+		},
+	}
+	zones := map[string]string{
+		instanceKey("node3", "wowza-edge"): "b",
+		instanceKey("node1", "wowza-edge"): "a",
+		instanceKey("node4", "wowza-edge"): "b",
+		instanceKey("node2", "wowza-edge"): "c",
+	}
+	for _, c := range []struct {
+		st       SelectionStrategy
+		expected string
+	}{
+		{SelectionStrategy{Kind: StrategyCatalog}, "node4"},
+		{SelectionStrategy{Kind: StrategyFewestConnections}, "node1"},
+		{SelectionStrategy{Kind: StrategyUnhealthyFirst}, "node4"},
+		{SelectionStrategy{Kind: StrategyNodeName}, "node1"},
+		{SelectionStrategy{Kind: StrategyZoneRoundRobin}, "node1"},
+		{SelectionStrategy{Kind: StrategyOrderFile, Order: []string{"node2"}}, "node2"},
+	} {
+		info.Zones = nil
+		if c.st.NeedsZones() {
+			info.Zones = zones
+		}
+		ordered := c.st.WithHealthPolicy(HealthFailingFirst).Sort(services, image, info)
+		s, err := SelectNextService(ordered, image, info.Health, HealthFailingFirst, 0, nil)
+		if err != nil || s.Node != c.expected {
+			t.Error("Strategy", c.st.Kind, "should update", c.expected, "first, got", nodes(ordered), err)
+		}
+	}
+}
+
+func TestSelectionStrategySortByZone(t *testing.T) {
+	image := Tag{Key: "image", Value: "wowza:2"}
+	services := healthServices("b2", "a2", "b1", "a1")
+	info := SelectionInfo{Zones: map[string]string{}}
+	for _, s := range services {
+		info.Zones[instanceKey(s.Node, s.ServiceID)] = s.Node[:1]
+	}
+	if s := (SelectionStrategy{Kind: StrategyCatalog}).Sort(services, image, info); !sameNodes(s, "a2", "a1", "b2", "b1") {
+		t.Error("Zones should be finished one after the other, in catalog order", nodes(s))
+	}
+	if s := (SelectionStrategy{Kind: StrategyNodeName}).Sort(services, image, info); !sameNodes(s, "a1", "a2", "b1", "b2") {
+		t.Error("The strategy should order the instances of a zone", nodes(s))
+	}
+	if s := (SelectionStrategy{Kind: StrategyZoneRoundRobin}).Sort(services, image, info); !sameNodes(s, "a2", "b2", "a1", "b1") {
+		t.Error("Zones should alternate", nodes(s))
+	}
+}
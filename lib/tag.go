@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Tag for a service, a tag without value is a bare flag tag such as v1
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// ParseTag builds a tag from key=value or a bare key, the value is everything after the first =
+func ParseTag(s string) (Tag, error) {
+	if s == "" {
+		return Tag{}, errors.New("empty tag")
+	}
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return Tag{Key: s}, nil
+	}
+	if i == 0 {
+		return Tag{}, fmt.Errorf("invalid tag %q, key is empty", s)
+	}
+	if i == len(s)-1 {
+		return Tag{}, fmt.Errorf("invalid tag %q, value is empty", s)
+	}
+	return Tag{Key: s[:i], Value: s[i+1:]}, nil
+}
+
+// BuildTag build a tag string from a tag struct
+func (t Tag) BuildTag() (string, error) {
+	if t.Key == "" || strings.Contains(t.Key, "=") {
+		err := errors.New("Should not build tag with empty key or key containing =")
+		fmt.Println("Error while building tag :", err.Error())
+		return "", err
+	}
+	if t.Value == "" {
+		return t.Key, nil
+	}
+	return fmt.Sprintf("%s=%s", t.Key, t.Value), nil
+}
+
+//DeconstructTag allow to construct a tag from a given string containing key/value separated with =
+func (t *Tag) DeconstructTag(tag string) error {
+	parsed, err := ParseTag(tag)
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// TagSet is the list of tags of a service in registration order. Tags which can't be parsed are
+// kept as they are.
+type TagSet []string
+
+// NewTagSet copies tags into a TagSet
+func NewTagSet(tags []string) TagSet {
+	return append(TagSet{}, tags...)
+}
+
+// Has reports whether the set holds the tag
+func (ts TagSet) Has(tag Tag) bool {
+	for _, t := range ts {
+		if parsed, err := ParseTag(t); err == nil && parsed == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the first tag with the given key
+func (ts TagSet) Get(key string) (Tag, bool) {
+	for _, t := range ts {
+		if parsed, err := ParseTag(t); err == nil && parsed.Key == key {
+			return parsed, true
+		}
+	}
+	return Tag{}, false
+}
+
+// Add appends the tag unless the set already holds it, it reports whether the set changed
+func (ts *TagSet) Add(tag Tag) (bool, error) {
+	s, err := tag.BuildTag()
+	if err != nil {
+		return false, err
+	}
+	if ts.Has(tag) {
+		return false, nil
+	}
+	*ts = append(*ts, s)
+	return true, nil
+}
+
+// Remove drops the tag, it reports whether the set changed
+func (ts *TagSet) Remove(tag Tag) bool {
+	return ts.filter(func(t Tag) bool { return t == tag })
+}
+
+// RemoveKey drops every tag with the given key, it reports whether the set changed
+func (ts *TagSet) RemoveKey(key string) bool {
+	return ts.filter(func(t Tag) bool { return t.Key == key })
+}
+
+// Replace drops the other tags with the key of tag and adds tag, it reports whether the set changed
+func (ts *TagSet) Replace(tag Tag) (bool, error) {
+	if _, err := tag.BuildTag(); err != nil {
+		return false, err
+	}
+	removed := ts.filter(func(t Tag) bool { return t.Key == tag.Key && t != tag })
+	added, err := ts.Add(tag)
+	return removed || added, err
+}
+
+// filter drops the tags matching drop, it reports whether a tag was dropped
+func (ts *TagSet) filter(drop func(Tag) bool) bool {
+	var kept TagSet
+	for _, t := range *ts {
+		if parsed, err := ParseTag(t); err == nil && drop(parsed) {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	changed := len(kept) != len(*ts)
+	*ts = kept
+	return changed
+}
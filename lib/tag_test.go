@@ -0,0 +1,60 @@
+package lib
+
+import "testing"
+
+func TestParseTag(t *testing.T) {
+	cases := map[string]Tag{
+		"image=registry:5000/wowza:1": {Key: "image", Value: "registry:5000/wowza:1"},
+		"opts=a=1":                    {Key: "opts", Value: "a=1"},
+		"v1":                          {Key: "v1"},
+	}
+	for s, expected := range cases {
+		tag, err := ParseTag(s)
+		if err != nil || tag != expected {
+			t.Error("Unexpected parse of", s, tag, err)
+		}
+		if built, _ := tag.BuildTag(); built != s {
+			t.Error("Tag should build back to", s, "got", built)
+		}
+	}
+	for _, s := range []string{"", "=foo", "foo="} {
+		if _, err := ParseTag(s); err == nil {
+			t.Error("Malformed tag should be refused:", s)
+		}
+	}
+}
+
+func TestDeconstructTagShouldNotPanicOnBareTag(t *testing.T) {
+	tag := &Tag{}
+	if err := tag.DeconstructTag("foo"); err != nil || tag.Key != "foo" || tag.Value != "" {
+		t.Error("Bare tag should be parsed", tag, err)
+	}
+}
+
+func TestTagSet(t *testing.T) {
+	tags := NewTagSet([]string{"v1", "update=wowza:1", "=weird", "image=wowza:1"})
+	if !tags.Has(Tag{Key: "v1"}) || !tags.Has(Tag{Key: "image", Value: "wowza:1"}) || tags.Has(Tag{Key: "image"}) {
+		t.Error("Unexpected Has", tags)
+	}
+	if added, err := tags.Add(Tag{Key: "v1"}); added || err != nil {
+		t.Error("Tag v1 is already there", added, err)
+	}
+	if _, err := tags.Add(Tag{}); err == nil {
+		t.Error("Empty tag should be refused")
+	}
+	if changed, _ := tags.Replace(Tag{Key: "update", Value: "wowza:2"}); !changed {
+		t.Error("Update tag should be replaced")
+	}
+	if tags.Has(Tag{Key: "update", Value: "wowza:1"}) || !tags.Has(Tag{Key: "update", Value: "wowza:2"}) {
+		t.Error("Only update=wowza:2 should be left", tags)
+	}
+	if !tags.Remove(Tag{Key: "v1"}) || tags.Remove(Tag{Key: "v1"}) {
+		t.Error("v1 should be removed once", tags)
+	}
+	if !tags.RemoveKey("image") {
+		t.Error("image tags should be removed", tags)
+	}
+	if len(tags) != 2 || tags[0] != "=weird" || tags[1] != "update=wowza:2" {
+		t.Error("Unexpected tags left", tags)
+	}
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// SplitImage splits a docker image reference into its repository and tag
+func SplitImage(image string) (string, string) {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image, ""
+	}
+	return image[:i], image[i+1:]
+}
+
+func imageRegexp(repository string) *regexp.Regexp {
+	return regexp.MustCompile(`(^|[^A-Za-z0-9_./-])` + regexp.QuoteMeta(repository) + `:[A-Za-z0-9_][A-Za-z0-9_.-]*`)
+}
+
+// RewriteUnitImage replaces every tag of the image repository referenced in the unit file
+// with the tag of image. It returns whether the file has been modified.
+func RewriteUnitImage(path string, image string) (bool, error) {
+	repository, tag := SplitImage(image)
+	if tag == "" {
+		return false, fmt.Errorf("image %s has no tag", image)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	re := imageRegexp(repository)
+	if !re.Match(content) {
+		return false, fmt.Errorf("unit file %s does not reference image %s", path, repository)
+	}
+	rewritten := re.ReplaceAll(content, []byte("${1}"+image))
+	if string(rewritten) == string(content) {
+		return false, nil
+	}
+	return true, ioutil.WriteFile(path, rewritten, 0644)
+}
+
+// CheckUnitImage checks that the unit file parses and only references the image repository with
+// the tag of image
+func CheckUnitImage(path string, image string) error {
+	if _, err := getUnitFromFile(path); err != nil {
+		return fmt.Errorf("unable to parse unit file %s: %v", path, err)
+	}
+	repository, tag := SplitImage(image)
+	if tag == "" {
+		return fmt.Errorf("image %s has no tag", image)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	matches := imageRegexp(repository).FindAllSubmatch(content, -1)
+	if len(matches) == 0 {
+		return fmt.Errorf("unit file %s does not reference image %s", path, repository)
+	}
+	for _, m := range matches {
+		if referenced := string(m[0][len(m[1]):]); referenced != image {
+			return fmt.Errorf("unit file %s references %s instead of %s", path, referenced, image)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const wowzaUnit = `[Unit]
+Description=Wowza origin
+
+[Service]
+ExecStartPre=-/usr/bin/docker pull eu.gcr.io/scalezen/wowza_bundle:0.3.3
+ExecStart=/usr/bin/docker run --name wowza eu.gcr.io/scalezen/wowza_bundle:0.3.3
+ExecStartPost=/usr/bin/docker run eu.gcr.io/scalezen/wowza_bundle_sidecar:0.3.3
+`
+
+func writeUnit(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "units")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "wowza-origin@.service")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSplitImage(t *testing.T) {
+	repository, tag := SplitImage("registry:5000/wowza:1.2")
+	if repository != "registry:5000/wowza" || tag != "1.2" {
+		t.Error("Unexpected split of registry:5000/wowza:1.2 :", repository, tag)
+	}
+	repository, tag = SplitImage("registry:5000/wowza")
+	if repository != "registry:5000/wowza" || tag != "" {
+		t.Error("Unexpected split of registry:5000/wowza :", repository, tag)
+	}
+}
+
+func TestRewriteUnitImageReplacesOnlyTheImage(t *testing.T) {
+	path := writeUnit(t, wowzaUnit)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	changed, err := RewriteUnitImage(path, "eu.gcr.io/scalezen/wowza_bundle:0.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Error("Unit file should have been rewritten")
+	}
+	content, _ := ioutil.ReadFile(path)
+	if strings.Count(string(content), "wowza_bundle:0.3.4") != 2 {
+		t.Error("Both references to the image should have been rewritten :", string(content))
+	}
+	if !strings.Contains(string(content), "wowza_bundle_sidecar:0.3.3") {
+		t.Error("Another image should not have been rewritten :", string(content))
+	}
+
+	changed, err = RewriteUnitImage(path, "eu.gcr.io/scalezen/wowza_bundle:0.3.4")
+	if err != nil || changed {
+		t.Error("Rewriting with the same image should not change the file")
+	}
+}
+
+func TestRewriteUnitImageFailsOnUnknownImage(t *testing.T) {
+	path := writeUnit(t, wowzaUnit)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if _, err := RewriteUnitImage(path, "eu.gcr.io/scalezen/other:1"); err == nil {
+		t.Error("Should fail because unit file does not reference the image")
+	}
+	if _, err := RewriteUnitImage(path, "eu.gcr.io/scalezen/wowza_bundle"); err == nil {
+		t.Error("Should fail because image has no tag")
+	}
+}
+
+func TestCheckUnitImage(t *testing.T) {
+	path := writeUnit(t, wowzaUnit)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if err := CheckUnitImage(path, "eu.gcr.io/scalezen/wowza_bundle:0.3.3"); err != nil {
+		t.Error(err)
+	}
+	if err := CheckUnitImage(path, "eu.gcr.io/scalezen/wowza_bundle:0.3.4"); err == nil || !strings.Contains(err.Error(), "instead of") {
+		t.Error("The unit file still references 0.3.3", err)
+	}
+	if err := CheckUnitImage(path, "eu.gcr.io/scalezen/nginx:1"); err == nil {
+		t.Error("The unit file does not reference nginx")
+	}
+}
@@ -0,0 +1,627 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"wowza-rolling-update/digest"
+
+	"github.com/coreos/fleet/machine"
+	"github.com/hashicorp/consul/api"
+)
+
+// Updater rolls the instances of a Consul service to a new image by recreating their fleet units
+// one after the other, once their Wowza server has no connection left.
+//
+// The rollout loops until all image tags are equal to the update image:
+//   - search for a service without this image and tag it for update (primarily an already tagged service,
+//     then in Strategy order among the instances HealthPolicy lets update, following MinHealthy)
+//   - wait until this service has no connections
+//   - search the unit linked to that service
+//   - destroy the unit
+//   - start the unit
+type Updater struct {
+	Client *api.Client
+	// ConsulConfig is the configuration of Client, for the Consul requests the api package lacks
+	ConsulConfig *api.Config
+	Transport    *digest.Transport
+	Service      string
+	Dc           string
+	Image        string
+	// UnitPath is the template unit file, or the global unit file when GlobalUnit is set
+	UnitPath   string
+	UnitsDir   string
+	GlobalUnit bool
+	// Commit is the git commit of the units directory, if known
+	Commit string
+
+	FleetSSHUser    string
+	FleetSSHServer  string
+	MachineResolver MachineResolver
+	MachineSelector MachineSelector
+	ZoneKey         string
+	InstanceRule    InstanceRule
+
+	// HealthPolicy tells how instances with failing Consul checks are selected, MinHealthy is the
+	// number of passing instances which have to remain when a passing instance is taken down
+	HealthPolicy string
+	MinHealthy   int
+	// Strategy orders the instances to update, MetricsConcurrency and MetricsTimeout bound the
+	// Wowza requests of strategies needing connections
+	Strategy           SelectionStrategy
+	MetricsConcurrency int
+	MetricsTimeout     time.Duration
+
+	// UnitTimeout, UnitBlockAttempts and UnitPollInterval bound each fleet unit operation
+	UnitTimeout       time.Duration
+	UnitBlockAttempts int
+	UnitPollInterval  time.Duration
+
+	// WaitTime bounds each Consul blocking query waiting for the instances to change, RegisterTimeout
+	// bounds the wait for a recreated instance to register with the image
+	WaitTime        time.Duration
+	RegisterTimeout time.Duration
+
+	// MaxUtilisation is the percentage of the Wowza connections of the passing instances which may be
+	// used once another instance is drained, zero disables the guard. CapacityAction tells whether
+	// the rollout waits or stops when it would be exceeded.
+	MaxUtilisation float64
+	CapacityAction string
+
+	// Schedule pauses the rollout before a new instance outside its windows or during its blackouts
+	Schedule *Schedule
+
+	// SkipPreflight starts the rollout without pre-flight checks
+	SkipPreflight bool
+
+	// MaxAttempts is the number of failed attempts after which an instance is given up
+	MaxAttempts int
+
+	// TagUpdater writes the update tags of instances, tags are registered in the catalog when nil
+	TagUpdater *TagUpdater
+
+	// StateMode tells where the rollout state of instances is stored: auto, meta or tags.
+	// RolloutID identifies the rollout in service meta, a random one is used when empty.
+	StateMode string
+	RolloutID string
+
+	Journal *Journal
+
+	state    RolloutState
+	attempts map[string]int
+	report   RolloutReport
+	stage    string
+	node     string
+	critical string
+	// watcher follows fleet states for every unit operation of the rollout
+	watcher *UnitStateWatcher
+}
+
+// Stage describes where the rollout is, or where it stopped
+func (u *Updater) Stage() string {
+	if u.node == "" {
+		return u.stage
+	}
+	return fmt.Sprintf("%s on node %s", u.stage, u.node)
+}
+
+func (u *Updater) setStage(stage string, node string) {
+	u.stage = stage
+	u.node = node
+}
+
+func (u *Updater) record(e JournalEntry) {
+	e.Service = u.Service
+	e.Dc = u.Dc
+	e.Image = u.Image
+	e.Commit = u.Commit
+	e.Rollout = u.state.ID
+	u.Journal.Record(e)
+}
+
+// initState resolves where the rollout state of instances is stored
+func (u *Updater) initState() error {
+	tagUpdater := u.TagUpdater
+	if tagUpdater == nil {
+		tagUpdater = defaultTagUpdater
+	}
+	mode, err := ResolveRolloutStateMode(u.StateMode, tagUpdater.Strategy, func() (bool, error) { return MetaSupported(u.Client) })
+	if err != nil {
+		return err
+	}
+	id := u.RolloutID
+	if id == "" {
+		id = NewRolloutID()
+	}
+	u.state = RolloutState{Mode: mode, ID: id, Image: u.Image, Commit: u.Commit, StartedAt: time.Now()}
+	log.Println("Rollout", id, "keeps the state of instances in service", mode)
+	return nil
+}
+
+// unitOptions returns the options of a fleet unit operation. Unit operations are bounded by
+// UnitTimeout only: once a unit is destroyed it has to be started again even if the rollout
+// is interrupted.
+func (u *Updater) unitOptions() (UnitOptions, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), u.UnitTimeout)
+	return UnitOptions{Context: ctx, BlockAttempts: u.UnitBlockAttempts, PollInterval: u.UnitPollInterval, Watcher: u.watcher}, cancel
+}
+
+func (u *Updater) waitTime() time.Duration {
+	if u.WaitTime <= 0 {
+		return time.Minute
+	}
+	return u.WaitTime
+}
+
+func (u *Updater) registerTimeout() time.Duration {
+	if u.RegisterTimeout <= 0 {
+		return 5 * time.Minute
+	}
+	return u.RegisterTimeout
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// Run rolls the instances until every one of them runs the update image or ctx is done.
+// When interrupted, the node being drained gets its rollout state removed. Instances failing
+// MaxAttempts times are given up, the rollout then fails once the other ones are updated.
+func (u *Updater) Run(ctx context.Context) error {
+	if !u.SkipPreflight {
+		if err := u.Preflight(ctx); err != nil {
+			return err
+		}
+	}
+	if err := u.initState(); err != nil {
+		return err
+	}
+	cAPI, err := GetClient(u.FleetSSHUser, u.FleetSSHServer)
+	if err != nil {
+		return fmt.Errorf("unable to initialize fleet client: %v", err)
+	}
+	u.watcher = NewUnitStateWatcher(&cAPI, u.UnitPollInterval)
+	u.watcher.Start()
+	defer u.watcher.Stop()
+	u.record(JournalEntry{Event: "rollout-start"})
+	defer func() { u.report.log() }()
+	queryOpts := (&api.QueryOptions{Datacenter: u.Dc}).WithContext(ctx)
+
+	var index uint64
+	for {
+		u.setStage("searching next instance", "")
+		var err error
+		index, err = u.waitChange(ctx, index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return u.interrupted(ctx.Err(), nil)
+			}
+			return err
+		}
+		catalogServices, _, err := u.Client.Catalog().Service(u.Service, "", queryOpts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return u.interrupted(ctx.Err(), nil)
+			}
+			return ExplainConsulError(err, "service:read on "+u.Service)
+		}
+		// the capacity is the one of every instance, selected or not
+		allServices := catalogServices
+		var machines []machine.MachineState
+		if len(u.MachineSelector) > 0 || u.ZoneKey != "" {
+			machines, err = ListFleetMachines(u.FleetSSHUser, u.FleetSSHServer)
+			if err != nil {
+				log.Println(err)
+				// fleet is retried without waiting for a change in Consul
+				index = 0
+				if err := sleep(ctx, 3*time.Second); err != nil {
+					return u.interrupted(err, nil)
+				}
+				continue
+			}
+			catalogServices = SelectServices(catalogServices, machines, u.MachineResolver, u.MachineSelector)
+		}
+		catalogServices = u.withoutFailed(catalogServices)
+		// search if we already have a service already waiting for an update
+		service, found := SearchService(catalogServices, u.state.Draining)
+		if !found {
+			health, err := GetServiceHealth(u.Client, u.Service, queryOpts)
+			if err != nil {
+				if ctx.Err() != nil {
+					return u.interrupted(ctx.Err(), nil)
+				}
+				return err
+			}
+			u.reportCritical(catalogServices, health)
+			info := u.selectionInfo(ctx, catalogServices, health, machines)
+			ordered := u.Strategy.WithHealthPolicy(u.HealthPolicy).Sort(catalogServices, u.state.ImageLabel(), info)
+			next, err := SelectNextService(ordered, u.state.ImageLabel(), health, u.HealthPolicy, u.MinHealthy, info.Zones)
+			if errors.Is(err, ErrMinHealthy) {
+				log.Println("Waiting before updating another instance:", err)
+				continue
+			} else if err != nil {
+				log.Println(err)
+				if u.GlobalUnit {
+					u.destroyMigratedGlobalUnit()
+				}
+				u.setStage("finished", "")
+				return u.finish(catalogServices)
+			}
+			// the schedule is only checked between instances, a node being updated is always finished
+			paused, err := u.waitSchedule(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return u.interrupted(ctx.Err(), nil)
+				}
+				return err
+			}
+			if paused {
+				// the instances are read again once resumed
+				index = 0
+				continue
+			}
+			if err := u.checkCapacity(ctx, next, allServices, health); err != nil {
+				if ctx.Err() != nil {
+					return u.interrupted(ctx.Err(), nil)
+				}
+				if u.CapacityAction == CapacityAbort {
+					u.record(JournalEntry{Event: "capacity-exceeded", Node: next.Node, Message: err.Error()})
+					return err
+				}
+				log.Println("Waiting before updating another instance:", err)
+				u.record(JournalEntry{Event: "capacity-wait", Node: next.Node, Message: err.Error()})
+				continue
+			}
+			service = *next
+		}
+		cs := &CatalogService{Dc: u.Dc, Cs: &service, TagUpdater: u.TagUpdater}
+		err = u.updateNode(ctx, cs)
+		switch {
+		case ctx.Err() != nil:
+			return u.interrupted(ctx.Err(), cs)
+		case errors.Is(err, ErrAttemptFailed):
+			u.attemptFailed(ctx, cs, err)
+		case err != nil:
+			return err
+		case u.state.Updated(cs.Cs):
+			u.report.Updated = append(u.report.Updated, cs.Cs.Node)
+		}
+	}
+}
+
+// waitSchedule pauses until the schedule allows updating a new instance, it reports whether it paused
+func (u *Updater) waitSchedule(ctx context.Context) (bool, error) {
+	if u.Schedule == nil {
+		return false, nil
+	}
+	now := time.Now()
+	open, reason := u.Schedule.Open(now)
+	if open {
+		return false, nil
+	}
+	next, err := u.Schedule.NextOpen(now)
+	if err != nil {
+		return false, err
+	}
+	u.setStage("paused until "+next.Format(time.RFC3339), "")
+	log.Println("Rollout paused,", reason+", resuming at", next.Format(time.RFC3339))
+	u.record(JournalEntry{Event: "paused", Message: reason})
+	if err := sleep(ctx, time.Until(next)); err != nil {
+		return true, err
+	}
+	log.Println("Rollout resumed")
+	u.record(JournalEntry{Event: "resumed"})
+	return true, nil
+}
+
+// waitChange blocks until the instances of the service or their checks change since index, at
+// most WaitTime. It returns the index of the next wait, a zero index returns at once.
+func (u *Updater) waitChange(ctx context.Context, index uint64) (uint64, error) {
+	q := (&api.QueryOptions{Datacenter: u.Dc, WaitIndex: index, WaitTime: u.waitTime()}).WithContext(ctx)
+	_, meta, err := u.Client.Health().Service(u.Service, "", false, q)
+	if err != nil {
+		return 0, ExplainConsulError(err, "service:read on "+u.Service+" and node:read")
+	}
+	return nextIndex(index, meta.LastIndex), nil
+}
+
+// selectionInfo gathers what the selection strategy needs to order the instances
+func (u *Updater) selectionInfo(ctx context.Context, services []*api.CatalogService, health ServiceHealth, machines []machine.MachineState) SelectionInfo {
+	info := SelectionInfo{Health: health}
+	if u.Strategy.NeedsConnections() {
+		var outdated []*api.CatalogService
+		var urls []string
+		for _, s := range services {
+			cs := &CatalogService{Dc: u.Dc, Cs: s}
+			if !u.state.Updated(s) {
+				outdated = append(outdated, s)
+				urls = append(urls, cs.GetURL())
+			}
+		}
+		info.Connections = make(map[string]int32)
+		for i, r := range CollectMetrics(ctx, urls, u.Transport, u.MetricsConcurrency, u.MetricsTimeout) {
+			if r.Err != nil {
+				log.Println("Unable to retrieve wowza metrics for node", outdated[i].Node, r.Err)
+				continue
+			}
+			info.Connections[instanceKey(outdated[i].Node, outdated[i].ServiceID)] = r.Metrics.CurrentConnections
+		}
+	}
+	if u.ZoneKey != "" {
+		info.Zones = make(map[string]string)
+		for _, s := range services {
+			if m, err := u.MachineResolver.Resolve(machines, s); err == nil {
+				info.Zones[instanceKey(s.Node, s.ServiceID)] = m.Metadata[u.ZoneKey]
+			}
+		}
+	}
+	return info
+}
+
+// reportCritical logs the outdated instances whose checks fail, once per change of the set
+func (u *Updater) reportCritical(services []*api.CatalogService, health ServiceHealth) {
+	var nodes []string
+	for _, s := range health.Failing(services) {
+		if !u.state.Updated(s) {
+			nodes = append(nodes, fmt.Sprintf("%s (%s)", s.Node, health.Status(s)))
+		}
+	}
+	report := strings.Join(nodes, ", ")
+	if report == u.critical {
+		return
+	}
+	u.critical = report
+	if report == "" {
+		log.Println("No outdated instance with failing checks left")
+		return
+	}
+	action := "updated first"
+	switch u.HealthPolicy {
+	case HealthSkipFailing:
+		action = "skipped"
+	case HealthIgnore:
+		action = "updated in catalog order"
+	}
+	log.Println("Outdated instances with failing checks, "+action+":", report)
+	u.record(JournalEntry{Event: "critical", Message: report})
+}
+
+// interrupted cleans up the node being drained and reports where the rollout stopped. The rollout
+// context is done, the rollout state is removed within a context of its own.
+func (u *Updater) interrupted(err error, cs *CatalogService) error {
+	stage := u.Stage()
+	if cs != nil && u.stage == "draining" {
+		log.Println("Removing rollout state from node", cs.Cs.Node)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		u.untagNode(ctx, cs)
+		cancel()
+	}
+	log.Println("Rollout of", u.Service, "to", u.Image, "stopped while", stage)
+	u.record(JournalEntry{Event: "interrupted", Message: stage})
+	return err
+}
+
+func (u *Updater) tagNode(ctx context.Context, cs *CatalogService) error {
+	if u.state.Draining(cs.Cs) {
+		return nil
+	}
+	if err := u.state.Mark(ctx, u.Client, cs, PhaseDraining); err != nil {
+		return err
+	}
+	u.record(JournalEntry{Event: "tagged", Node: cs.Cs.Node})
+	return nil
+}
+
+func (u *Updater) untagNode(ctx context.Context, cs *CatalogService) {
+	if err := u.state.Clear(ctx, u.Client, cs); err != nil {
+		log.Println(err)
+	}
+	u.record(JournalEntry{Event: "untagged", Node: cs.Cs.Node})
+}
+
+// waitDrained waits until the Wowza server of the service has no connection left
+func (u *Updater) waitDrained(ctx context.Context, cs *CatalogService) error {
+	for {
+		currentConnections, err := GetMetricsWithContext(ctx, cs.GetURL(), u.Transport)
+		if err != nil {
+			log.Println("Unable to retrieve wowza metrics for service", cs.Cs.ServiceName, cs.Cs.ServiceAddress, cs.GetURL())
+		} else {
+			log.Println(currentConnections.CurrentConnections, "connections left in", cs.Cs.ServiceName, cs.Cs.ServiceAddress)
+			if currentConnections.CurrentConnections == 0 {
+				return nil
+			}
+		}
+		if err := sleep(ctx, 3*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+func (u *Updater) updateNode(ctx context.Context, cs *CatalogService) error {
+	u.setStage("tagging", cs.Cs.Node)
+	if err := u.tagNode(ctx, cs); err != nil {
+		return err
+	}
+	log.Println("Found service")
+
+	u.setStage("draining", cs.Cs.Node)
+	if err := u.waitDrained(ctx, cs); err != nil {
+		return err
+	}
+	currentConnectionsRenew, err := GetMetricsWithContext(ctx, cs.GetURL(), u.Transport)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: unable to retrieve wowza metrics from %s: %v", ErrAttemptFailed, cs.GetURL(), err)
+	}
+	if currentConnectionsRenew.CurrentConnections != 0 {
+		return nil
+	}
+	log.Println(cs.Cs.ServiceName, cs.Cs.Address, cs.Cs.Node, currentConnectionsRenew.CurrentConnections, "connections")
+
+	u.setStage("recreating units", cs.Cs.Node)
+	if err := u.state.Mark(ctx, u.Client, cs, PhaseRecreating); err != nil {
+		log.Println(err)
+	}
+	// the units are recreated even if ctx is done meanwhile, a destroyed unit is always started again
+	if err := u.recreateUnits(ctx, cs); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// waitDeregistered waits until the instance leaves the catalog once its unit is destroyed
+func (u *Updater) waitDeregistered(ctx context.Context, cs *CatalogService) {
+	q := &api.QueryOptions{Datacenter: u.Dc}
+	_, err := WaitService(ctx, u.Client, u.Service, q, time.Now().Add(30*time.Second), func(services []*api.CatalogService) bool {
+		return findInstance(services, cs.Cs) == nil
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Println("Service", cs.Cs.ServiceID, "is still registered on node", cs.Cs.Node+":", err)
+	}
+}
+
+// recreateUnits destroys and starts again the fleet units of the service instance. ctx only
+// cuts the waits for Consul: a destroyed unit is always started again. It fails when the
+// instance started does not register with the image.
+func (u *Updater) recreateUnits(ctx context.Context, cs *CatalogService) error {
+	// search fleet machine
+	unitList, _ := ListFleetUnits(u.FleetSSHUser, u.FleetSSHServer)
+
+	cAPI, err := GetClient(u.FleetSSHUser, u.FleetSSHServer)
+	if err != nil {
+		return fmt.Errorf("%w: unable to initialize fleet client: %v", ErrAttemptFailed, err)
+	}
+	machines, err := cAPI.Machines()
+	if err != nil {
+		return fmt.Errorf("%w: unable to list fleet machines: %v", ErrAttemptFailed, err)
+	}
+	// select machine where service is running
+	machine, err := u.MachineResolver.Resolve(machines, cs.Cs)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAttemptFailed, err)
+	}
+	serviceUnits, err := FindServiceUnits(unitList, TemplateName(u.Service), machine.ID, cs.Cs, u.InstanceRule)
+	if err != nil {
+		if !u.GlobalUnit {
+			return fmt.Errorf("%w: %v", ErrAttemptFailed, err)
+		}
+		log.Println(err)
+	}
+	if len(serviceUnits) == 0 && !u.GlobalUnit {
+		return fmt.Errorf("%w: no unit of %s found on machine %s", ErrAttemptFailed, TemplateName(u.Service), machine.ID)
+	}
+	var unitErr, registerErr error
+	for _, unit := range serviceUnits {
+		units := []string{unit.Name}
+		opts, cancel := u.unitOptions()
+		err = RunDestroyUnit(units, &cAPI, opts)
+		cancel()
+		if err != nil {
+			// never start a unit over one which may still be running
+			log.Println("Unable to destroy unit", unit.Name, "on server", machine.PublicIP, err)
+			u.record(JournalEntry{Event: "destroy-failed", Node: cs.Cs.Node, Unit: unit.Name, Message: err.Error()})
+			unitErr = fmt.Errorf("%w: unable to destroy unit %s: %v", ErrAttemptFailed, unit.Name, err)
+			continue
+		}
+		log.Println("Destroyed unit", unit.Name, "on server", machine.PublicIP)
+		u.record(JournalEntry{Event: "destroyed", Node: cs.Cs.Node, Unit: unit.Name})
+		u.waitDeregistered(ctx, cs)
+		if u.GlobalUnit {
+			continue
+		}
+		unitFile := fmt.Sprintf("%s/%s", u.UnitsDir, unit.Name)
+		units = []string{unitFile}
+		opts, cancel = u.unitOptions()
+		err = RunStartUnit(units, &cAPI, opts)
+		cancel()
+		if err != nil {
+			u.unitStartFailed(cs, unit.Name, err)
+			unitErr = fmt.Errorf("%w: unable to start unit %s: %v", ErrAttemptFailed, unit.Name, err)
+			continue
+		}
+		log.Println("Start unit", unit.Name, "with file")
+		u.record(JournalEntry{Event: "started", Node: cs.Cs.Node, Unit: unit.Name})
+		// the other units are started again before failing
+		if err := u.waitRegistered(ctx, cs, unit.Name); err != nil && registerErr == nil {
+			registerErr = err
+		}
+	}
+	if u.GlobalUnit {
+		if FindGlobalUnit(unitList, u.Service) != nil {
+			err = StopGlobalUnitOnMachine(u.FleetSSHUser, u.FleetSSHServer, machine, GlobalUnitName(u.Service))
+			if err != nil {
+				return fmt.Errorf("%w: unable to stop global unit on server %s: %v", ErrAttemptFailed, machine.PublicIP, err)
+			}
+			log.Println("Stopped global unit", GlobalUnitName(u.Service), "on server", machine.PublicIP)
+		}
+		name := PinnedInstanceName(u.Service, machine)
+		opts, cancel := u.unitOptions()
+		err = StartPinnedUnit(name, u.UnitPath, machine, &cAPI, opts)
+		cancel()
+		if err != nil {
+			u.unitStartFailed(cs, name, err)
+			return fmt.Errorf("%w: unable to start unit %s: %v", ErrAttemptFailed, name, err)
+		}
+		log.Println("Start unit", name, "pinned on server", machine.PublicIP)
+		u.record(JournalEntry{Event: "started", Node: cs.Cs.Node, Unit: name})
+		return u.waitRegistered(ctx, cs, name)
+	}
+	if registerErr != nil {
+		return registerErr
+	}
+	return unitErr
+}
+
+// unitStartFailed reports a unit which did not start, in time or at all
+func (u *Updater) unitStartFailed(cs *CatalogService, name string, err error) {
+	event := "start-failed"
+	if IsUnitTimeout(err) {
+		event = "start-timeout"
+	}
+	log.Println("Unable to start unit", name, "on node", cs.Cs.Node, err)
+	u.record(JournalEntry{Event: event, Node: cs.Cs.Node, Unit: name, Message: err.Error()})
+}
+
+// destroyMigratedGlobalUnit destroys the global unit once a pinned instance replaced it on every machine
+func (u *Updater) destroyMigratedGlobalUnit() {
+	cAPI, err := GetClient(u.FleetSSHUser, u.FleetSSHServer)
+	if err != nil {
+		log.Println("Unable to initialize client:", err)
+		return
+	}
+	unitList, err := cAPI.Units()
+	if err != nil || FindGlobalUnit(unitList, u.Service) == nil {
+		return
+	}
+	states, err := cAPI.UnitStates()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	machines, err := cAPI.Machines()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !GlobalUnitMigrated(u.Service, unitList, states, machines) {
+		log.Println("Global unit", GlobalUnitName(u.Service), "still runs on machines without pinned instance, keeping it")
+		return
+	}
+	opts, cancel := u.unitOptions()
+	defer cancel()
+	if err := RunDestroyUnit([]string{GlobalUnitName(u.Service)}, &cAPI, opts); err != nil {
+		log.Println("Unable to destroy global unit", GlobalUnitName(u.Service), err)
+		return
+	}
+	u.record(JournalEntry{Event: "destroyed", Unit: GlobalUnitName(u.Service)})
+}
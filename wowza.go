@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"regexp"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 	"wowza-rolling-update/digest"
 	"wowza-rolling-update/lib"
@@ -18,52 +21,163 @@ var (
 	datacenterName      = flag.String("dc", "", "Consul datacenter")
 	tagOpts             = flag.String("tag", "", "Tag (key=value)")
 	addTagActionOpts    = flag.Bool("add-tag", false, "Add tag")
-	deleteTagActionOpts = flag.Bool("delete-tag", false, "Delete tag")
+	deleteTagActionOpts = flag.Bool("delete-tag", false, "Delete tag, a tag without value deletes every tag with this key")
+	setTagActionOpts    = flag.Bool("set-tag", false, "Set tag on all service nodes, replacing tags with the same key")
+	nodeName            = flag.String("node", "", "Only set or delete tags on this Consul node")
+	rolloutStateOpts    = flag.String("rollout-state", lib.StateAuto, "Where the rollout state of instances is stored: auto, meta (service meta, needs -tag-update agent) or tags (update= and commit= tags)")
+	rolloutID           = flag.String("rollout-id", "", "Identifier of the rollout in service meta, random when empty")
+	consulAddress       = flag.String("consul-address", "", "Consul HTTP address (host:port), CONSUL_HTTP_ADDR or 127.0.0.1:8500 by default")
+	consulScheme        = flag.String("consul-scheme", "", "Consul HTTP scheme: http or https, https when TLS files are given")
+	consulToken         = flag.String("consul-token", "", "Consul ACL token, CONSUL_HTTP_TOKEN by default")
+	consulTokenFile     = flag.String("consul-token-file", "", "File holding the Consul ACL token")
+	consulNamespace     = flag.String("consul-namespace", "", "Consul Enterprise namespace of the service")
+	consulCAFile        = flag.String("consul-ca-file", "", "PEM CA certificate verifying the Consul server")
+	consulCertFile      = flag.String("consul-cert-file", "", "PEM client certificate presented to Consul")
+	consulKeyFile       = flag.String("consul-key-file", "", "PEM key of the client certificate")
+	consulServerName    = flag.String("consul-tls-server-name", "", "Name checked against the Consul server certificate")
+	tagUpdateOpts       = flag.String("tag-update", lib.TagUpdateCatalog, "How service tags are written: catalog or agent (through the Consul agent of the node)")
+	consulAgentPort     = flag.Int("consul-agent-port", lib.DefaultAgentPort, "HTTP port of Consul agents for -tag-update agent")
 	listActionOpts      = flag.Bool("list", false, "List services")
+	listUnitsActionOpts = flag.Bool("list-units", false, "List fleet units")
+	listMachinesOpts    = flag.Bool("list-machines", false, "List fleet machines")
+	outputOpts          = flag.String("output", lib.OutputTable, "Output format of listings: table, json, yaml or csv")
+	statusActionOpts    = flag.Bool("status", false, "Show the rollout status of services to the -update image")
 	unit                = flag.String("unit", "", "Unit to start")
 	update              = flag.String("update", "", "Image to update to")
 	unitsDir            = flag.String("units-dir", ".", "Path to directory of fleet unit files")
 	fleetSSHServer      = flag.String("fleet-ssh-server", "", "A server to SSH for Fleet API")
 	fleetSSHUser        = flag.String("fleet-ssh-user", "core", "SSH username")
+	gitVerify           = flag.Bool("git-verify", true, "Require units directory to be a clean git working tree")
+	gitCommit           = flag.Bool("git-commit", false, "Rewrite the unit file image with the update image and commit it")
+	journalPath         = flag.String("journal", "", "Path of the file recording rollout events")
+	machineResolverOpts = flag.String("machine-resolver", lib.MachineByIP, "How Consul services map to fleet machines: ip, lan, wan, node, machine-id[:<key>] or metadata:<key>")
+	machineSelectorOpts = flag.String("machine-selector", "", "Only update instances on fleet machines with this metadata (key=value,...)")
+	zoneKey             = flag.String("zone-key", "", "Fleet machine metadata key holding the zone of instances, updated zone by zone or alternately with -strategy zone-round-robin")
+	unitTimeout         = flag.Duration("unit-timeout", 5*time.Minute, "Maximum time to wait for a unit to be started or destroyed")
+	unitBlockAttempts   = flag.Int("unit-block-attempts", 0, "Number of unit state polls before giving up, 0 polls until -unit-timeout, negative does not wait")
+	unitPollInterval    = flag.Duration("unit-poll-interval", 500*time.Millisecond, "Delay between two unit state polls")
+	healthPolicyOpts    = flag.String("health-policy", lib.HealthFailingFirst, "How outdated instances with failing Consul checks are updated: first, skip or ignore")
+	minHealthy          = flag.Int("min-healthy", 0, "Minimum number of passing instances left when a passing instance is taken down")
+	strategyOpts        = flag.String("strategy", lib.StrategyCatalog, "Order of instances to update: catalog, fewest-connections, unhealthy-first, node, zone-round-robin (with -zone-key) or order-file:<path>")
+	metricsConcurrency  = flag.Int("metrics-concurrency", 8, "Maximum number of Wowza metrics requests in flight when listing, ordering or showing the status of services")
+	metricsTimeout      = flag.Duration("metrics-timeout", 5*time.Second, "Maximum time to wait for the metrics of a Wowza server")
+	unitInstance        = flag.String("unit-instance", lib.InstanceByMachine, "How fleet unit instances map to Consul services: machine, port, node or tag:<key>")
+	consulWait          = flag.Duration("consul-wait", time.Minute, "Longest Consul blocking query while waiting for instances or their checks to change")
+	registerTimeout     = flag.Duration("register-timeout", 5*time.Minute, "Maximum time to wait for a recreated instance to register in Consul with the update image")
+	maxAttempts         = flag.Int("max-attempts", 3, "Number of failed attempts after which an instance is given up and left outdated")
+	maxUtilisation      = flag.Float64("max-utilisation", 0, "Maximum percentage of Wowza connections used on the other passing instances once a node is drained, 0 disables the guard")
+	capacityActionOpts  = flag.String("capacity-action", lib.CapacityWait, "What to do when draining a node would exceed -max-utilisation: wait or abort")
+	preflight           = flag.Bool("preflight", true, "Check Consul, fleet, units, Wowza and healthy capacity before updating any instance")
+	configPath          = flag.String("config", "", "JSON configuration file listing the datacenters to roll in order with their fleet endpoint, and the maintenance schedule")
 )
 
 func main() {
 	transport := digest.NewTransport("admin", "admin.123")
 	flag.Parse()
-
-	if *listActionOpts {
-		client, err := api.NewClient(api.DefaultConfig())
+	output, err := lib.ParseOutputFormat(*outputOpts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	tagUpdateStrategy, err := lib.ParseTagUpdateStrategy(*tagUpdateOpts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	consulOpts := lib.ConsulOptions{
+		Address:    *consulAddress,
+		Scheme:     *consulScheme,
+		Token:      *consulToken,
+		TokenFile:  *consulTokenFile,
+		Namespace:  *consulNamespace,
+		CAFile:     *consulCAFile,
+		CertFile:   *consulCertFile,
+		KeyFile:    *consulKeyFile,
+		ServerName: *consulServerName,
+	}
+	client, consulConfig, err := lib.NewConsulClient(consulOpts)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	tagUpdater := &lib.TagUpdater{Strategy: tagUpdateStrategy, Config: consulConfig, AgentPort: *consulAgentPort}
+	var config lib.Config
+	if *configPath != "" {
+		config, err = lib.LoadConfig(*configPath)
 		if err != nil {
-			panic(err)
+			fmt.Println(err)
+			os.Exit(1)
 		}
+	}
+	multiDC := len(config.Datacenters) > 0
+	if multiDC && *datacenterName != "" {
+		fmt.Println("-dc can't be given with the datacenters of", *configPath)
+		os.Exit(1)
+	}
 
+	if *listActionOpts {
 		queryOpts := &api.QueryOptions{
 			Datacenter: *datacenterName,
 		}
 
 		catalogServices, _, err := client.Catalog().Service(*serviceName, "", queryOpts)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Println(lib.ExplainConsulError(err, "service:read on "+*serviceName))
 			os.Exit(1)
 		}
+		var urls []string
 		for _, s := range catalogServices {
 			cs := lib.CatalogService{Dc: *datacenterName, Cs: s}
-			currentConnections, _ := lib.GetMetrics(cs.GetURL(), transport)
-			fmt.Printf("[%s] node:%s lan:%s wan:%s tags:%s current_connections:%d\n",
-				s.ServiceName,
-				s.Node,
-				s.TaggedAddresses["lan"],
-				s.TaggedAddresses["wan"],
-				s.ServiceTags,
-				currentConnections.CurrentConnections,
-			)
+			urls = append(urls, cs.GetURL())
+		}
+		metrics := lib.CollectMetrics(context.Background(), urls, transport, *metricsConcurrency, *metricsTimeout)
+		records := []lib.ServiceRecord{}
+		for i, s := range catalogServices {
+			records = append(records, lib.NewServiceRecord(s, metrics[i]))
+		}
+		if err := lib.WriteServiceList(os.Stdout, output, records); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if (*listUnitsActionOpts || *listMachinesOpts) && *fleetSSHServer != "" {
+		cAPI, err := lib.GetClient(*fleetSSHUser, *fleetSSHServer)
+		if err != nil {
+			fmt.Printf("Unable to initialize client: %v\n", err)
+			os.Exit(1)
+		}
+		if *listMachinesOpts {
+			machines, err := cAPI.Machines()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			err = lib.WriteMachineList(os.Stdout, output, lib.NewMachineRecords(machines))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		} else {
+			units, err := cAPI.Units()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			states, err := cAPI.UnitStates()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			err = lib.WriteUnitList(os.Stdout, output, lib.NewUnitRecords(units, states))
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
 		}
 	} else if *addTagActionOpts && *tagOpts != "" {
-		var tag lib.Tag
-		tag.DeconstructTag(*tagOpts)
-		client, err := api.NewClient(api.DefaultConfig())
+		tag, err := lib.ParseTag(*tagOpts)
 		if err != nil {
-			panic(err)
+			fmt.Println(err)
+			os.Exit(1)
 		}
 
 		queryOpts := &api.QueryOptions{
@@ -72,7 +186,7 @@ func main() {
 
 		catalogServices, _, err := client.Catalog().Service(*serviceName, "", queryOpts)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Println(lib.ExplainConsulError(err, "service:read on "+*serviceName))
 			os.Exit(1)
 		}
 		service, err := lib.SearchServiceWithoutTag(catalogServices, tag)
@@ -80,16 +194,17 @@ func main() {
 			fmt.Println(err)
 			os.Exit(0)
 		}
-		cs := lib.CatalogService{Dc: *datacenterName, Cs: &service}
+		cs := lib.CatalogService{Dc: *datacenterName, Cs: &service, TagUpdater: tagUpdater}
 		err = cs.ServiceAddTag(client, &service, tag)
 		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
 	} else if *deleteTagActionOpts && *tagOpts != "" {
-		var tag lib.Tag
-		tag.DeconstructTag(*tagOpts)
-		client, err := api.NewClient(api.DefaultConfig())
+		tag, err := lib.ParseTag(*tagOpts)
 		if err != nil {
-			panic(err)
+			fmt.Println(err)
+			os.Exit(1)
 		}
 
 		queryOpts := &api.QueryOptions{
@@ -98,119 +213,228 @@ func main() {
 
 		catalogServices, _, err := client.Catalog().Service(*serviceName, "", queryOpts)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Println(lib.ExplainConsulError(err, "service:read on "+*serviceName))
 			os.Exit(1)
 		}
 		for _, service := range catalogServices {
-			cs := lib.CatalogService{Dc: *datacenterName, Cs: service}
-			cs.ServiceDeleteTag(client, service, tag)
-		}
-	} else if *update != "" && *serviceName != "" && *datacenterName != "" && *unitsDir != "" && *fleetSSHServer != "" {
-		unitPath := fmt.Sprintf("%s/%s@.service", *unitsDir, *serviceName)
-		if _, err := os.Stat(unitPath); os.IsNotExist(err) {
-			log.Println(err)
-			os.Exit(1)
+			if *nodeName != "" && service.Node != *nodeName {
+				continue
+			}
+			cs := lib.CatalogService{Dc: *datacenterName, Cs: service, TagUpdater: tagUpdater}
+			if tag.Value == "" {
+				err = cs.ServiceDeleteTagKey(client, service, tag.Key)
+			} else {
+				err = cs.ServiceDeleteTag(client, service, tag)
+			}
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
 		}
-		client, err := api.NewClient(api.DefaultConfig())
+	} else if *setTagActionOpts && *tagOpts != "" {
+		tag, err := lib.ParseTag(*tagOpts)
 		if err != nil {
-			panic(err)
+			fmt.Println(err)
+			os.Exit(1)
 		}
 
 		queryOpts := &api.QueryOptions{
 			Datacenter: *datacenterName,
 		}
 
-		// loop start
-		// search for a service without this image name and tag it for future update (primarily includes already tagged service)
-		// wait until this service has no connections
-		// search unit linked to that service
-		// destroy unit
-		// start unit
-		// loop until all image tag are equals to update tag
-
-		updateTag := lib.Tag{Key: "update", Value: *update}
-		alreadyUpdatedTag := lib.Tag{Key: "image", Value: *update}
-
-		loopIndex := 1
-		for {
-			time.Sleep(3 * time.Second)
-			catalogServices, _, err := client.Catalog().Service(*serviceName, "", queryOpts)
-			if err != nil {
+		catalogServices, _, err := client.Catalog().Service(*serviceName, "", queryOpts)
+		if err != nil {
+			fmt.Println(lib.ExplainConsulError(err, "service:read on "+*serviceName))
+			os.Exit(1)
+		}
+		for _, service := range catalogServices {
+			if *nodeName != "" && service.Node != *nodeName {
+				continue
+			}
+			cs := lib.CatalogService{Dc: *datacenterName, Cs: service, TagUpdater: tagUpdater}
+			if err := cs.ServiceSetTag(client, service, tag); err != nil {
 				fmt.Println(err)
-				break
+				os.Exit(1)
 			}
-			// search if we already have a service already waiting for an update
-			service, err := lib.SearchServiceWithTag(catalogServices, updateTag)
+		}
+	} else if *statusActionOpts && *update != "" && *serviceName != "" {
+		status, err := lib.GetRolloutStatus(context.Background(), client, transport, *serviceName, *datacenterName, *update, *metricsConcurrency, *metricsTimeout)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if *fleetSSHServer != "" {
+			instanceRule, err := lib.ParseInstanceRule(*unitInstance)
 			if err != nil {
-				service, err = lib.SearchServiceWithoutTag(catalogServices, alreadyUpdatedTag)
-				if err != nil {
-					log.Println(err)
-					break
-				}
+				log.Println(err)
+				os.Exit(1)
 			}
-			cs := lib.CatalogService{Dc: *datacenterName, Cs: &service}
-			if !cs.HasTag(updateTag) {
-				err = cs.ServiceAddTag(client, &service, updateTag)
-				if err != nil {
-					log.Println(err)
-					break
-				}
+			machineResolver, err := lib.ParseMachineResolver(*machineResolverOpts)
+			if err != nil {
+				log.Println(err)
+				os.Exit(1)
 			}
-			log.Println("Found service")
-
-			for {
-				currentConnections, errs := lib.GetMetrics(cs.GetURL(), transport)
-				if errs != nil {
-					log.Println("Unable to retrieve wowza metrics for service", cs.Cs.ServiceName, cs.Cs.ServiceAddress, cs.GetURL())
-					continue
-				}
-				log.Println(currentConnections.CurrentConnections, "connections left in", cs.Cs.ServiceName, cs.Cs.ServiceAddress)
-				if currentConnections.CurrentConnections == 0 {
-					break
-				}
-				time.Sleep(3 * time.Second)
+			cAPI, err := lib.GetClient(*fleetSSHUser, *fleetSSHServer)
+			if err != nil {
+				log.Println("Unable to initialize client:", err)
+				os.Exit(1)
 			}
-			currentConnectionsRenew, err := lib.GetMetrics(cs.GetURL(), transport)
+			units, err := cAPI.Units()
 			if err != nil {
-				log.Println("Unable to retrieve wowza metrics for service", cs.Cs.ServiceName, cs.Cs.ServiceAddress, cs.GetURL())
-				continue
+				log.Println(err)
+				os.Exit(1)
 			}
-			if currentConnectionsRenew.CurrentConnections == 0 {
-				log.Println(cs.Cs.ServiceName, cs.Cs.Address, cs.Cs.Node, currentConnectionsRenew.CurrentConnections, "connections")
-				// search fleet machine
-				unitList, _ := lib.ListFleetUnits(*fleetSSHUser, *fleetSSHServer)
-
-				cAPI, err := lib.GetClient(*fleetSSHUser, *fleetSSHServer)
-				if err != nil {
-					fmt.Printf("Unable to initialize client: %v", err)
-					continue
-				}
-				machines, err := cAPI.Machines()
+			states, err := cAPI.UnitStates()
+			if err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			machines, err := cAPI.Machines()
+			if err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			status.AddUnits(units, states, machines, machineResolver, instanceRule)
+		}
+		if *journalPath != "" {
+			entries, err := lib.ReadJournal(*journalPath)
+			if err != nil {
+				log.Println("Unable to read journal:", err)
+			}
+			status.EstimateETA(entries)
+		}
+		if err := lib.WriteRolloutStatus(os.Stdout, output, status); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if *update != "" && *serviceName != "" && *unitsDir != "" && (multiDC || *datacenterName != "" && *fleetSSHServer != "") {
+		unitPath := fmt.Sprintf("%s/%s", *unitsDir, lib.TemplateName(*serviceName))
+		globalUnit := false
+		if _, err := os.Stat(unitPath); os.IsNotExist(err) {
+			// a global unit is rolled machine by machine with instances of the template pinned on each machine
+			globalPath := fmt.Sprintf("%s/%s", *unitsDir, lib.GlobalUnitName(*serviceName))
+			globalUnit, _ = lib.IsGlobalUnitFile(globalPath)
+			if !globalUnit {
+				log.Println(err)
+				os.Exit(1)
+			}
+			unitPath = globalPath
+			log.Println("Rolling global unit", lib.GlobalUnitName(*serviceName), "with instances pinned on each machine")
+		}
+		instanceRule, err := lib.ParseInstanceRule(*unitInstance)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		machineResolver, err := lib.ParseMachineResolver(*machineResolverOpts)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		machineSelector, err := lib.ParseMachineSelector(*machineSelectorOpts)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		healthPolicy, err := lib.ParseHealthPolicy(*healthPolicyOpts)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		stateMode, err := lib.ParseRolloutStateMode(*rolloutStateOpts)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		capacityAction, err := lib.ParseCapacityAction(*capacityActionOpts)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		strategy, err := lib.ParseSelectionStrategy(*strategyOpts)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		if strategy.NeedsZones() && *zoneKey == "" {
+			log.Println("Strategy", *strategyOpts, "requires -zone-key")
+			os.Exit(1)
+		}
+		var commit string
+		if *gitVerify || *gitCommit {
+			repo := lib.GitRepository{Dir: *unitsDir}
+			commit, err = repo.CheckClean()
+			if err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			if *gitCommit {
+				changed, err := lib.RewriteUnitImage(unitPath, *update)
 				if err != nil {
-					log.Println("error while retrieving machines")
-					log.Println(err.Error())
+					log.Println(err)
+					os.Exit(1)
 				}
-				for _, machine := range machines {
-					// select machine where service is running
-					if machine.PublicIP == cs.Cs.Address {
-						for _, unit := range unitList {
-							unitFound, _ := regexp.MatchString(fmt.Sprintf("%s@.*.service", *serviceName), unit.Name)
-							if unit.MachineID == machine.ID && unitFound {
-								units := []string{unit.Name}
-								lib.RunDestroyUnit(units, &cAPI)
-								log.Println("Destroyed unit", unit.Name, "on server", machine.PublicIP)
-								time.Sleep(3 * time.Second)
-								unitFile := fmt.Sprintf("%s/%s", *unitsDir, unit.Name)
-								units = []string{unitFile}
-								lib.RunStartUnit(units, &cAPI)
-								log.Println("Start unit", unit.Name, "with file")
-								time.Sleep(30 * time.Second)
-							}
-						}
+				if changed {
+					commit, err = repo.Commit(fmt.Sprintf("Update %s to %s", *serviceName, *update), filepath.Base(unitPath))
+					if err != nil {
+						log.Println(err)
+						os.Exit(1)
 					}
+					log.Println("Committed", unitPath, "as", lib.ShortCommit(commit))
 				}
 			}
-			loopIndex += loopIndex
+			log.Println("Units directory", *unitsDir, "is at commit", lib.ShortCommit(commit))
+		}
+
+		updater := &lib.Updater{
+			Client:             client,
+			ConsulConfig:       consulConfig,
+			Transport:          transport,
+			Service:            *serviceName,
+			Dc:                 *datacenterName,
+			Image:              *update,
+			UnitPath:           unitPath,
+			UnitsDir:           *unitsDir,
+			GlobalUnit:         globalUnit,
+			Commit:             commit,
+			FleetSSHUser:       *fleetSSHUser,
+			FleetSSHServer:     *fleetSSHServer,
+			MachineResolver:    machineResolver,
+			MachineSelector:    machineSelector,
+			ZoneKey:            *zoneKey,
+			InstanceRule:       instanceRule,
+			HealthPolicy:       healthPolicy,
+			MinHealthy:         *minHealthy,
+			Strategy:           strategy,
+			MetricsConcurrency: *metricsConcurrency,
+			MetricsTimeout:     *metricsTimeout,
+			UnitTimeout:        *unitTimeout,
+			UnitBlockAttempts:  *unitBlockAttempts,
+			UnitPollInterval:   *unitPollInterval,
+			WaitTime:           *consulWait,
+			RegisterTimeout:    *registerTimeout,
+			MaxAttempts:        *maxAttempts,
+			MaxUtilisation:     *maxUtilisation,
+			CapacityAction:     capacityAction,
+			SkipPreflight:      !*preflight,
+			Schedule:           config.Schedule,
+			TagUpdater:         tagUpdater,
+			StateMode:          stateMode,
+			RolloutID:          *rolloutID,
+			Journal:            lib.NewJournal(*journalPath),
+		}
+		// the first signal lets the current step finish, a unit destroyed is always started again
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		if multiDC {
+			rollout := &lib.MultiDCRollout{Base: *updater, Datacenters: config.Datacenters, Soak: time.Duration(config.Soak)}
+			err = rollout.Run(ctx)
+		} else {
+			err = updater.Run(ctx)
+		}
+		stop()
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
 		}
 
 	} else {
@@ -238,6 +462,6 @@ func main() {
 	// 	os.Exit(1)
 	// }
 	// units := []string{*unit}
-	// //lib.RunStartUnit(units, &cAPI)
-	// lib.RunDestroyUnit(units, &cAPI)
+	// //lib.RunStartUnit(units, &cAPI, lib.UnitOptions{})
+	// lib.RunDestroyUnit(units, &cAPI, lib.UnitOptions{})
 }